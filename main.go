@@ -5,15 +5,28 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
 	"github.com/kylemcc/terragrunt-v19-upgrade/version"
 
@@ -22,10 +35,12 @@ import (
 	hclv1parser "github.com/hashicorp/hcl/hcl/parser"
 	hclv1token "github.com/hashicorp/hcl/hcl/token"
 	hclv2 "github.com/hashicorp/hcl/v2"
+	hclv2gohcl "github.com/hashicorp/hcl/v2/gohcl"
 	hclv2parse "github.com/hashicorp/hcl/v2/hclparse"
 	hclv2syntax "github.com/hashicorp/hcl/v2/hclsyntax"
 	hclv2write "github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/hashicorp/terraform/tfdiags"
+	"github.com/kylelemons/godebug/diff"
 )
 
 const name = "terragrunt-v19-upgrade"
@@ -77,15 +92,155 @@ var (
 	}
 )
 
-var errNotTerragruntConfig = errors.New("file does not contain a terragrunt attribute")
+var (
+	errNotTerragruntConfig = errors.New("file does not contain a terragrunt attribute")
+	errAlreadyUpgraded     = errors.New("file appears to already be upgraded")
+	errDestExists          = errors.New("destination already exists")
+	errInvalidOutput       = errors.New("upgraded config is invalid")
+	errMergeConflict       = errors.New("cannot merge fragments")
+	errJSONInput           = errors.New("JSON configs are not supported. convert the config to HCL, e.g. with json2hcl, and upgrade that instead")
+	errQuit                = errors.New("quit")
+	errEditAborted         = errors.New("editing was aborted")
+	errEmptyStdin          = errors.New("no input read from stdin. pipe a terragrunt <= v0.18 config to upgrade it, e.g. cat terraform.tfvars | " + name + " -")
+)
 
 type command struct {
-	recursive bool
-	gitMv     bool
-	dryRun    bool
-	keepOld   bool
+	recursive          bool
+	gitMv              bool
+	dryRun             bool
+	keepOld            bool
+	inPlace            bool
+	noInputsBlock      bool
+	addMigrationNote   bool
+	stdout             bool
+	only               string
+	dumpAST            bool
+	validateTerragrunt bool
+	keepInterpolation  bool
+	allowUnbounded     bool
+	expandEnv          bool
+	sortInputs         bool
+	listFiles          bool
+	merge              bool
+	diffOnlyChanged    bool
+	batchGit           bool
+	interactive        bool
+	renameInHeredocs   bool
+	strict             bool
+	noFormat           bool
+	tfFiles            bool
+	resolveIncludes    bool
+	editInEditor       bool
+	warningsAsErrors   bool
+	stdoutJSON         bool
+	progress           bool
+	quiet              bool
+	force              bool
+	verbose            bool
+	indent             string
+	targetVersion      string
+	fromVersion        string
+	reportPath         string
+	manifestPath       string
+	previewDir         string
+	concatPath         string
+	maxDepth           int
+	logLevel           string
+	removedSettings    string
+	since              string
+	includeFrom        string
+	excludeFrom        string
+	excludeDirs        stringList
+	rewriteSources     stringList
+	renameFunctions    stringList
+
+	// state for the config currently being upgraded
+	file     string
+	sources  []mergedSource
+	rules    renderRules
+	funcs    funcUpgrades
+	stats    upgradeStats
+	writeErr error
+
+	// with --parallel-safe-git, the files tracked by git, and the renames
+	// to stage once every file has been upgraded
+	tracked map[string]bool
+	moves   []gitMove
+
+	// with --interactive, where answers to prompts are read from, and
+	// whether the remaining files should be saved without prompting
+	answers  *bufio.Reader
+	applyAll bool
+
+	// with --concat, the file every upgraded config is appended to
+	concat io.Writer
+
+	// the number of warnings, for --warnings-as-errors. counted even if
+	// they aren't printed
+	warnings int
+
+	// with --progress, whether it's updated on a single line of the
+	// terminal, and whether that line is showing and has to be cleared
+	// before anything else is printed
+	progressTTY   bool
+	progressShown bool
+}
+
+// gitMove is a rename of a file from one path to another that has been made
+// on disk, but not yet staged in git.
+type gitMove struct {
+	from, to string
+}
+
+// invalidOutputError is returned by upgrade when the upgraded config is not
+// valid hcl v2. It wraps errInvalidOutput.
+type invalidOutputError struct {
+	diags hclv2.Diagnostics
+	files map[string]*hclv2.File
+}
+
+func (e *invalidOutputError) Error() string {
+	var d tfdiags.Diagnostics
+	d = d.Append(e.diags)
+	return fmt.Sprintf("%v: %v", errInvalidOutput, d.Err())
+}
+
+func (e *invalidOutputError) Unwrap() error {
+	return errInvalidOutput
+}
+
+// writeDiagnostics writes every diagnostic to w, along with its severity,
+// position, and the snippet of the upgraded config it refers to.
+func (e *invalidOutputError) writeDiagnostics(w io.Writer) error {
+	return hclv2.NewDiagnosticTextWriter(w, e.files, 78, false).WriteDiagnostics(e.diags)
+}
+
+// upgradeStats counts the changes made while upgrading a config.
+type upgradeStats struct {
+	renames        int
+	interpolations int
+}
+
+// reportEntry describes the result of processing a single file for the
+// --report output.
+type reportEntry struct {
+	Source         string   `json:"source"`
+	Destination    string   `json:"destination,omitempty"`
+	Status         string   `json:"status"`
+	Error          string   `json:"error,omitempty"`
+	Renames        int      `json:"renames"`
+	Interpolations int      `json:"interpolations"`
+	Problems       []string `json:"problems,omitempty"`
+	Merged         []string `json:"merged,omitempty"`
 }
 
+const (
+	statusUpgraded = "upgraded"
+	statusChanged  = "changed"
+	statusSkipped  = "skipped"
+	statusError    = "error"
+)
+
 func main() {
 	p := cli.NewProgram()
 	p.Name = name
@@ -98,18 +253,70 @@ func main() {
 	p.FlagSet = flag.NewFlagSet("global", flag.ExitOnError)
 	p.FlagSet.BoolVar(&cmd.recursive, "r", false, "Search subdirectores for terraform.tfvars files")
 	p.FlagSet.BoolVar(&cmd.recursive, "recursive", false, "Search subdirectores for terraform.tfvars files")
+	cmd.excludeDirs = stringList{values: defaultExcludeDirs}
+	p.FlagSet.BoolVar(&cmd.allowUnbounded, "allow-unbounded", false, "Allow -r to search directories outside of the current git repository")
+	p.FlagSet.Var(&cmd.excludeDirs, "exclude-dir", "Skip directories with this name or path when recursing. May be repeated")
+	p.FlagSet.StringVar(&cmd.includeFrom, "include-from", "", "Only upgrade configs matching one of the glob patterns in this file, one per line, relative to the current directory")
+	p.FlagSet.StringVar(&cmd.excludeFrom, "exclude-from", "", "Skip configs matching one of the glob patterns in this file, one per line, relative to the current directory")
+	p.FlagSet.BoolVar(&cmd.listFiles, "list-files", false, "Print the files that would be upgraded and exit without reading or upgrading them")
+	p.FlagSet.BoolVar(&cmd.merge, "merge", false, "Merge the input variables from other .tfvars files next to each terraform.tfvars file into its upgraded config")
+	p.FlagSet.StringVar(&cmd.manifestPath, "manifest", "", "Write a CSV file mapping the path of each upgraded file to the path of its upgraded config to this path")
+	p.FlagSet.StringVar(&cmd.since, "since", "", "Only upgrade configs that have changed since this git ref, including uncommitted changes, e.g. \"origin/master\"")
+	p.FlagSet.IntVar(&cmd.maxDepth, "max-depth", 0, "Do not search more than this many directories below each directory argument when recursing (0 for no limit)")
 	p.FlagSet.BoolVar(&cmd.gitMv, "m", false, "Update files in place and \"git mv terraform.tfvars terragrunt.hcl\"")
 	p.FlagSet.BoolVar(&cmd.gitMv, "git-mv", false, "Update files in place and \"git mv terraform.tfvars terragrunt.hcl\"")
+	p.FlagSet.BoolVar(&cmd.editInEditor, "edit-in-editor", false, "Open the upgraded config in $EDITOR before it is saved, and save it as edited. Only a single file can be upgraded this way")
+	p.FlagSet.BoolVar(&cmd.diffOnlyChanged, "diff-only-changed", false, "Print only the paths of files that would be upgraded, without updating them, and exit with status 1 if there are any")
+	p.FlagSet.BoolVar(&cmd.batchGit, "parallel-safe-git", false, "With --git-mv, stage all of the renames with a few git commands once every file has been upgraded, instead of running \"git mv\" for each file")
 	p.FlagSet.BoolVar(&cmd.dryRun, "d", false, "Do not update any files, just print changes to stdout")
 	p.FlagSet.BoolVar(&cmd.dryRun, "dry-run", false, "Do not update any files, just print changes to stdout")
 	p.FlagSet.BoolVar(&cmd.keepOld, "k", false, "Keep old terraform.tfvars files")
 	p.FlagSet.BoolVar(&cmd.keepOld, "keep", false, "Keep old terraform.tfvars files")
-
+	p.FlagSet.BoolVar(&cmd.interactive, "interactive", false, "Print the changes to each file and ask before saving them. Disabled when reading from stdin or when stdout isn't a terminal")
+	p.FlagSet.BoolVar(&cmd.inPlace, "i", false, "Update files in place without renaming them")
+	p.FlagSet.BoolVar(&cmd.inPlace, "in-place", false, "Update files in place without renaming them")
+	p.FlagSet.BoolVar(&cmd.addMigrationNote, "add-migration-note", false, "Add a comment to the top of each upgraded config noting the tool version and date it was upgraded")
+	p.FlagSet.BoolVar(&cmd.stdout, "stdout", false, "Write the upgraded config to stdout instead of updating any files")
+	p.FlagSet.BoolVar(&cmd.stdoutJSON, "output-stdout-json", false, "Write each upgraded config to stdout as a JSON object with its path and content, one per line, instead of updating any files")
+	p.FlagSet.BoolVar(&cmd.expandEnv, "expand-env", false, "Expand shell-style environment variables ($VAR or ${VAR}) in strings")
+	p.FlagSet.BoolVar(&cmd.keepInterpolation, "keep-interpolation", false, "Do not remove the \"${...}\" wrapper from interpolations. Functions are still renamed")
+	p.FlagSet.StringVar(&cmd.removedSettings, "removed-settings", "drop", "What to do with terragrunt settings that terragrunt v0.19 no longer supports: \"drop\" them, or \"comment\" them out with a TODO")
+	p.FlagSet.Var(&cmd.renameFunctions, "rename-function", "Also rename calls to a function, given as old=new, e.g. \"get_account=get_aws_account_id\". May be repeated")
+	p.FlagSet.Var(&cmd.rewriteSources, "rewrite-sources", "Replace a prefix of terraform.source, given as prefix=replacement, e.g. \"../modules=git::git@github.com:foo/modules.git\". May be repeated")
+	p.FlagSet.StringVar(&cmd.only, "only", "", "Comma-separated list of terragrunt settings to upgrade, e.g. \"remote_state,include\". Other settings are dropped")
+	p.FlagSet.BoolVar(&cmd.renameInHeredocs, "rename-in-heredocs", false, "Rename functions called in interpolations in heredocs. Without it, heredocs are copied as they are")
+	p.FlagSet.BoolVar(&cmd.noFormat, "no-format", false, "Do not format the upgraded config, to debug formatting problems or use another formatter. Cannot be used with --indent")
+	p.FlagSet.BoolVar(&cmd.noInputsBlock, "no-inputs-block", false, "Write input variables as top-level attributes instead of in an inputs block")
+	p.FlagSet.BoolVar(&cmd.sortInputs, "sort-inputs", false, "Sort input variables by name")
+	p.FlagSet.StringVar(&cmd.concatPath, "concat", "", "Write every upgraded config to this one file, each under a header comment naming its source, for review. Nothing else is updated")
+	p.FlagSet.StringVar(&cmd.previewDir, "preview-dir", "", "Write each upgraded config under this directory, at the same path relative to the current directory, instead of updating any files")
+	p.FlagSet.BoolVar(&cmd.quiet, "q", false, "Do not print informational messages or warnings")
+	p.FlagSet.BoolVar(&cmd.quiet, "quiet", false, "Do not print informational messages or warnings")
+	p.FlagSet.BoolVar(&cmd.validateTerragrunt, "validate-terragrunt", false, "Check that each upgraded config has the blocks and attributes terragrunt requires, and warn about any problems")
+	p.FlagSet.StringVar(&cmd.logLevel, "log-level", "", "Print messages at this level and above: error, warn, info, or debug. Defaults to info, or error with --quiet and debug with --verbose")
+	p.FlagSet.BoolVar(&cmd.progress, "progress", false, "Print the number of files processed so far. When stdout is a terminal, it's updated on a single line")
+	p.FlagSet.BoolVar(&cmd.verbose, "verbose", false, "Print each decision made while upgrading a config to stderr")
+	p.FlagSet.BoolVar(&cmd.warningsAsErrors, "warnings-as-errors", false, "Exit with a non-zero status if there are any warnings, e.g. for a file that was ignored or part of a config that was dropped")
+	p.FlagSet.BoolVar(&cmd.strict, "strict", false, "Fail instead of warning when part of a config, such as a comment on the terragrunt attribute, would be lost")
+	p.FlagSet.BoolVar(&cmd.force, "force", false, "Upgrade files that appear to already be upgraded, and overwrite existing terragrunt.hcl files")
+	p.FlagSet.BoolVar(&cmd.dumpAST, "dump-ast", false, "Print the parsed config to stderr before upgrading it. For debugging")
+	p.FlagSet.StringVar(&cmd.indent, "indent", "2", "Number of spaces to indent each level of the upgraded config, or \"tab\" to indent with tabs")
+	p.FlagSet.StringVar(&cmd.reportPath, "report", "", "Write a JSON report describing each processed file to this path")
+	p.FlagSet.StringVar(&cmd.fromVersion, "from-version", defaultFromVersion, "The version of terragrunt the configs were written for, which decides the functions that are renamed")
+	p.FlagSet.StringVar(&cmd.targetVersion, "target-version", defaultTargetVersion, "The version of terragrunt to target when deciding how to render remote_state")
+	p.FlagSet.BoolVar(&cmd.resolveIncludes, "resolve-includes", false, "Also upgrade the parent config each config includes, found by following the path in its include block. Best effort")
+	p.FlagSet.BoolVar(&cmd.tfFiles, "tf-files", false, "Also search directories for .tf files that contain a terragrunt attribute. Best effort, for very old layouts")
+
+	// the defaults from the config file are loaded once the command line
+	// has been parsed, so they only apply to flags that weren't given on it
+	p.Before = func(ctx context.Context) error {
+		return loadDefaults(p.FlagSet, configFile)
+	}
 	p.Action = cmd.run
 	p.Run()
 }
 
-func (c *command) run(ctx context.Context, args []string) error {
+func (c *command) run(ctx context.Context, args []string) (err error) {
 	if err := c.validateArgs(args); err != nil {
 		return err
 	}
@@ -119,38 +326,587 @@ func (c *command) run(ctx context.Context, args []string) error {
 		return err
 	}
 
-	for _, p := range paths {
-		orig, err := c.readFile(p)
-		if err != nil {
+	if c.listFiles {
+		for _, p := range paths {
+			fmt.Println(p)
+		}
+		return nil
+	}
+
+	if c.stdout && len(paths) > 1 {
+		return fmt.Errorf("--stdout can only be used to upgrade a single file, but found %d", len(paths))
+	}
+
+	if c.editInEditor && len(paths) > 1 {
+		return fmt.Errorf("--edit-in-editor can only be used to upgrade a single file, but found %d", len(paths))
+	}
+
+	report := []reportEntry{}
+	if c.reportPath != "" {
+		// write the report even if processing a file fails
+		defer func() {
+			if rerr := writeReport(c.reportPath, report); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	}
+	if c.manifestPath != "" {
+		defer func() {
+			if merr := writeManifest(c.manifestPath, report); merr != nil && err == nil {
+				err = merr
+			}
+		}()
+	}
+
+	// on interrupt, finish upgrading the current file and then stop, rather
+	// than leaving it partially updated
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			// a second interrupt stops the process right away
+			signal.Stop(sigs)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if c.interactive && !isTerminal(os.Stdout) {
+		c.warnf("stdout is not a terminal, so --interactive is disabled")
+		c.interactive = false
+	}
+
+	if c.concatPath != "" {
+		f, ferr := os.Create(c.concatPath)
+		if ferr != nil {
+			return ferr
+		}
+		defer func() {
+			if cerr := f.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		c.concat = f
+	}
+
+	if c.batchGit && !c.dryRun && c.previewDir == "" && c.concatPath == "" {
+		if c.tracked, err = trackedFiles(paths); err != nil {
 			return err
 		}
+	}
 
-		upgraded, err := c.upgrade(orig)
-		if err == errNotTerragruntConfig {
-			fmt.Fprintf(os.Stderr, "warning: ignoring file %s. file does not contain a terragrunt attribute.", p)
-			continue
-		} else if err != nil {
-			return fmt.Errorf("error upgrading file %s: %v", p, err)
+	var failed, changed int
+	// with --parallel-safe-git, the renames of the files that were upgraded
+	// are staged even if the run stops early
+	stage := func() {
+		errs := stageMoves(c.moves)
+		c.moves = nil
+		for i := range report {
+			if err, ok := errs[report[i].Source]; ok {
+				c.errorf("%v", err)
+				report[i].Status = statusError
+				report[i].Error = err.Error()
+				failed++
+			}
+		}
+	}
+	defer stage()
+
+	if c.progress {
+		c.progressTTY = isTerminal(os.Stdout)
+		defer c.finishProgress()
+	}
+
+	for i, p := range paths {
+		if ctx.Err() != nil {
+			return fmt.Errorf("interrupted after upgrading %d of %d file(s)", i, len(paths))
 		}
 
-		if err := c.save(p, upgraded); err != nil {
+		entry, err := c.upgradeFile(p)
+		report = append(report, entry)
+		if err == errQuit {
+			c.infof("quitting. %d file(s) were not processed", len(paths)-i-1)
+			break
+		} else if err != nil {
 			return err
+		} else if entry.Status == statusError {
+			failed++
+		} else if entry.Status == statusChanged {
+			changed++
+		}
+
+		if c.progress {
+			c.printProgress(i+1, len(paths))
 		}
 	}
 
+	stage()
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) could not be upgraded", failed)
+	} else if c.warningsAsErrors && c.warnings > 0 {
+		return fmt.Errorf("%d warning(s) with --warnings-as-errors", c.warnings)
+	} else if changed > 0 {
+		return fmt.Errorf("%d file(s) would be upgraded", changed)
+	}
+
 	return nil
 }
 
+// printProgress prints the number of files processed so far, for
+// --progress. On a terminal, it replaces the previous count.
+func (c *command) printProgress(done, total int) {
+	if !c.progressTTY {
+		fmt.Printf("%d/%d\n", done, total)
+		return
+	}
+	fmt.Printf("\r\033[K%d/%d", done, total)
+	c.progressShown = true
+}
+
+// clearProgress erases the progress line from the terminal, if it's
+// showing, so that a message can be printed in its place.
+func (c *command) clearProgress() {
+	if c.progressShown {
+		fmt.Print("\r\033[K")
+		c.progressShown = false
+	}
+}
+
+// finishProgress ends the progress line, if it's showing, leaving the last
+// count on the terminal.
+func (c *command) finishProgress() {
+	if c.progressShown {
+		fmt.Println()
+		c.progressShown = false
+	}
+}
+
+// upgradeFile upgrades and saves the config at path, and returns an entry
+// describing the result for the report.
+func (c *command) upgradeFile(p string) (reportEntry, error) {
+	entry := reportEntry{Source: p, Status: statusError}
+
+	orig, err := c.readFile(p)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry, err
+	}
+
+	if p == "-" && len(bytes.TrimSpace(orig)) == 0 {
+		// nothing will be written to stdout, so make sure a pipeline
+		// doesn't mistake this for success
+		entry.Error = errEmptyStdin.Error()
+		return entry, errEmptyStdin
+	}
+
+	if !c.force && isUpgraded(orig) {
+		// Upgrading a config that has already been upgraded could corrupt
+		// it, so re-running the upgrade is a no-op
+		c.warnf("skipping file %s. file appears to already be upgraded. use --force to upgrade it anyway.", p)
+		if c.stdoutJSON {
+			if err := writeJSONLine(p, orig); err != nil {
+				entry.Error = err.Error()
+				return entry, err
+			}
+		} else if c.destPath(p) == "-" {
+			os.Stdout.Write(orig)
+		}
+		entry.Status = statusSkipped
+		entry.Error = errAlreadyUpgraded.Error()
+		return entry, nil
+	}
+
+	c.sources = nil
+	if c.merge {
+		merged, fragments, err := c.mergeFile(p, orig)
+		if errors.Is(err, errMergeConflict) {
+			// nothing has been written yet, so skip this file and move on
+			c.errorf("skipping file %s. %v", p, err)
+			entry.Error = err.Error()
+			return entry, nil
+		} else if err != nil {
+			err = fmt.Errorf("error merging file %s: %v", p, err)
+			entry.Error = err.Error()
+			return entry, err
+		}
+		orig, entry.Merged = merged, fragments
+	}
+
+	if filepath.Ext(p) == ".tf" {
+		c.warnf("upgrading %s is best effort. everything in a .tf file other than the terragrunt attribute, including any terraform resources or variables, is upgraded as an input variable, so check the upgraded config by hand", p)
+	}
+
+	c.file = p
+	upgraded, err := c.upgrade(orig)
+	entry.Renames = c.stats.renames
+	entry.Interpolations = c.stats.interpolations
+	if err == errNotTerragruntConfig && p == "-" {
+		err = errors.New("stdin does not contain a terragrunt attribute, so it does not appear to be a terragrunt <= v0.18 config")
+		entry.Error = err.Error()
+		return entry, err
+	} else if err == errNotTerragruntConfig {
+		c.warnf("ignoring file %s. file does not contain a terragrunt attribute.", p)
+		entry.Status = statusSkipped
+		entry.Error = err.Error()
+		return entry, nil
+	} else if errors.Is(err, errInvalidOutput) {
+		// nothing has been written yet, so skip this file and move on
+		var ioe *invalidOutputError
+		if errors.As(err, &ioe) {
+			ioe.writeDiagnostics(os.Stderr)
+		}
+		c.errorf("skipping file %s. %v", p, err)
+		entry.Error = err.Error()
+		return entry, nil
+	} else if err != nil && p == "-" {
+		err = fmt.Errorf("error upgrading stdin: %v", err)
+		entry.Error = err.Error()
+		return entry, err
+	} else if err != nil {
+		err = fmt.Errorf("error upgrading file %s: %v", p, err)
+		entry.Error = err.Error()
+		return entry, err
+	}
+
+	if c.validateTerragrunt {
+		// problems are only reported. the config is still written, since
+		// it's likely easier to fix by hand than the original
+		entry.Problems = checkTerragrunt(upgraded)
+		for _, problem := range entry.Problems {
+			c.warnf("%s: %s", p, problem)
+		}
+	}
+
+	if c.diffOnlyChanged {
+		// like gofmt -l, only the names of files that would change are
+		// printed, so the output can be piped to other commands
+		entry.Status = statusSkipped
+		if !bytes.Equal(orig, upgraded) {
+			fmt.Println(p)
+			entry.Status = statusChanged
+		}
+		return entry, nil
+	}
+
+	if dest := c.destPath(p); !c.force && c.overwrites(p, dest) {
+		// e.g. a partially completed migration. the existing config may
+		// have been edited by hand since it was upgraded
+		if _, err := os.Stat(dest); err == nil {
+			c.warnf("skipping file %s. %s already exists. use --force to overwrite it.", p, dest)
+			entry.Status = statusSkipped
+			entry.Error = errDestExists.Error()
+			return entry, nil
+		}
+	}
+
+	if c.interactive && !c.applyAll && !c.dryRun && c.destPath(p) != "-" {
+		apply, err := c.confirm(p, orig, upgraded)
+		if err == errQuit {
+			entry.Status = statusSkipped
+			return entry, err
+		} else if err != nil {
+			entry.Error = err.Error()
+			return entry, err
+		} else if !apply {
+			c.infof("Skipped %s", p)
+			entry.Status = statusSkipped
+			return entry, nil
+		}
+	}
+
+	if c.dryRun {
+		// a quick sense of how invasive the upgrade is, ahead of the
+		// upgraded config itself
+		c.clearProgress()
+		fmt.Printf("%s: %d line(s) changed (%d function(s) renamed, %d interpolation(s) unwrapped)\n",
+			p, changedLines(orig, upgraded), c.stats.renames, c.stats.interpolations)
+	}
+
+	if c.editInEditor {
+		edited, err := c.edit(upgraded)
+		if errors.Is(err, errEditAborted) {
+			// nothing has been written yet, so skip this file
+			c.errorf("skipping file %s. %v", p, err)
+			entry.Error = err.Error()
+			return entry, nil
+		} else if err != nil {
+			entry.Error = err.Error()
+			return entry, err
+		}
+		upgraded = edited
+	}
+
+	if err := c.save(p, upgraded); err != nil {
+		entry.Error = err.Error()
+		return entry, err
+	}
+
+	entry.Status = statusUpgraded
+	entry.Destination = c.destPath(p)
+	return entry, nil
+}
+
+// overwrites returns true if saving the upgraded config for path would
+// replace whatever is at dest, other than path itself. Previews and --concat
+// are written somewhere else, so they're not included.
+func (c *command) overwrites(path, dest string) bool {
+	return !c.dryRun && dest != "-" && dest != path && c.concat == nil && c.previewDir == ""
+}
+
+// confirm prints the changes to the file at path and asks whether to save
+// them. Answering "a" saves this and all remaining files without asking
+// again, and "q" returns errQuit.
+func (c *command) confirm(path string, orig, upgraded []byte) (bool, error) {
+	if c.answers == nil {
+		c.answers = bufio.NewReader(os.Stdin)
+	}
+
+	fmt.Printf("--- %s\n+++ %s\n%s\n", path, c.destPath(path), diff.Diff(string(orig), string(upgraded)))
+	for {
+		fmt.Print("Apply? [y/N/a/q] ")
+		answer, err := c.answers.ReadString('\n')
+		if err != nil && answer == "" {
+			// stdin was closed, so there's no one left to ask
+			fmt.Println()
+			return false, errQuit
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		case "", "n", "no":
+			return false, nil
+		case "a", "all":
+			c.applyAll = true
+			return true, nil
+		case "q", "quit":
+			return false, errQuit
+		}
+	}
+}
+
+// edit writes upgraded to a temporary file, opens it in $EDITOR, and returns
+// its contents once the editor exits. If the editor fails or the file is
+// emptied, errEditAborted is returned.
+func (c *command) edit(upgraded []byte) ([]byte, error) {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		return nil, errors.New("--edit-in-editor requires $EDITOR to be set")
+	}
+
+	f, err := ioutil.TempFile("", "terragrunt-*.hcl")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(upgraded)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(editor[0], append(editor[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errEditAborted, editor[0], err)
+	}
+
+	edited, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	} else if len(bytes.TrimSpace(edited)) == 0 {
+		return nil, fmt.Errorf("%w: the file was emptied", errEditAborted)
+	}
+	return edited, nil
+}
+
+// changedLines returns the number of lines that are added or removed by
+// upgrading orig to upgraded.
+func changedLines(orig, upgraded []byte) int {
+	var n int
+	for _, chunk := range diff.DiffChunks(strings.Split(string(orig), "\n"), strings.Split(string(upgraded), "\n")) {
+		n += len(chunk.Added) + len(chunk.Deleted)
+	}
+	return n
+}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// writeJSONLine writes the upgraded config for path to stdout as a JSON
+// object on a single line, for --output-stdout-json.
+func writeJSONLine(path string, contents []byte) error {
+	b, err := json.Marshal(struct {
+		Path    string `json:"path"`
+		Content string `json:"content"`
+	}{path, string(contents)})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", b)
+	return err
+}
+
+// writeReport writes the --report output to path.
+func writeReport(path string, report []reportEntry) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// writeManifest writes the --manifest output to path: a CSV file with the
+// source and destination paths of each file that was upgraded.
+func writeManifest(path string, report []reportEntry) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"source", "destination"})
+	for _, entry := range report {
+		if entry.Status == statusUpgraded && entry.Destination != "-" {
+			w.Write([]string{entry.Source, entry.Destination})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
 func (c *command) validateArgs(args []string) error {
 	if len(args) < 1 {
 		fmt.Fprintf(os.Stderr, "usage: %s [flags] [file|dir ...|-]\n\n", name)
 		return flag.ErrHelp
 	}
 
+	if indent, err := c.indentation(); err != nil {
+		return err
+	} else if c.noFormat && indent != defaultIndent {
+		return errors.New("--indent cannot be used with --no-format, since indentation is applied while formatting")
+	}
+
+	if _, err := c.renderRules(); err != nil {
+		return err
+	}
+
+	if _, err := c.funcUpgrades(); err != nil {
+		return err
+	}
+
+	if c.quiet && c.verbose {
+		return errors.New("--quiet and --verbose cannot be used together")
+	}
+
+	if c.logLevel != "" {
+		if _, ok := logLevels[c.logLevel]; !ok {
+			return fmt.Errorf("invalid --log-level %q. must be one of error, warn, info, or debug", c.logLevel)
+		} else if c.quiet || c.verbose {
+			return errors.New("--log-level cannot be used with --quiet or --verbose")
+		}
+	}
+
+	if c.maxDepth < 0 {
+		return errors.New("--max-depth must not be negative")
+	}
+
+	if c.removedSettings != "" && c.removedSettings != "drop" && c.removedSettings != "comment" {
+		return fmt.Errorf("invalid --removed-settings %q. must be drop or comment", c.removedSettings)
+	}
+
+	if c.inPlace && c.gitMv {
+		return errors.New("--in-place and --git-mv cannot be used together")
+	}
+
+	if _, err := c.sourceRewrites(); err != nil {
+		return err
+	}
+
+	if c.only != "" && len(c.onlySettings()) == 0 {
+		return errors.New("--only must list at least one terragrunt setting")
+	}
+	for _, setting := range strings.Split(c.only, ",") {
+		if setting = strings.TrimSpace(setting); setting != "" && !terragruntSettings[setting] {
+			var names []string
+			for name := range terragruntSettings {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("invalid --only setting %q. must be one of %s", setting, strings.Join(names, ", "))
+		}
+	}
+
+	if c.stdout && (c.inPlace || c.gitMv) {
+		return errors.New("--stdout cannot be used with --in-place or --git-mv")
+	}
+
+	if c.batchGit && !c.gitMv {
+		return errors.New("--parallel-safe-git can only be used with --git-mv")
+	}
+
+	if c.previewDir != "" && (c.dryRun || c.stdout || c.diffOnlyChanged) {
+		return errors.New("--preview-dir cannot be used with --dry-run, --stdout, or --diff-only-changed")
+	}
+
+	if c.concatPath != "" && (c.dryRun || c.stdout || c.diffOnlyChanged || c.previewDir != "") {
+		return errors.New("--concat cannot be used with --dry-run, --stdout, --diff-only-changed, or --preview-dir")
+	}
+
+	if c.diffOnlyChanged && (c.stdout || c.dryRun) {
+		return errors.New("--diff-only-changed cannot be used with --stdout or --dry-run")
+	}
+
+	if c.stdoutJSON && (c.dryRun || c.stdout || c.inPlace || c.gitMv || c.diffOnlyChanged || c.interactive || c.previewDir != "" || c.concatPath != "") {
+		return errors.New("--output-stdout-json cannot be used with --dry-run, --stdout, --in-place, --git-mv, --diff-only-changed, --interactive, --preview-dir, or --concat")
+	}
+
+	if c.progress && (c.stdout || c.stdoutJSON || c.diffOnlyChanged || c.interactive || c.editInEditor) {
+		return errors.New("--progress cannot be used with --stdout, --output-stdout-json, --diff-only-changed, --interactive, or --edit-in-editor")
+	}
+
+	if c.editInEditor {
+		if c.dryRun || c.stdout || c.diffOnlyChanged || c.interactive || c.concatPath != "" {
+			return errors.New("--edit-in-editor cannot be used with --dry-run, --stdout, --diff-only-changed, --interactive, or --concat")
+		} else if os.Getenv("EDITOR") == "" {
+			return errors.New("--edit-in-editor requires $EDITOR to be set")
+		}
+	}
+
 	if len(args) == 1 && args[0] == "-" {
+		if c.previewDir != "" {
+			return errors.New("--preview-dir cannot be used when reading from stdin")
+		} else if c.concatPath != "" {
+			return errors.New("--concat cannot be used when reading from stdin")
+		} else if c.editInEditor {
+			return errors.New("--edit-in-editor cannot be used when reading from stdin")
+		} else if c.resolveIncludes {
+			return errors.New("--resolve-includes cannot be used when reading from stdin")
+		} else if c.since != "" {
+			return errors.New("--since cannot be used when reading from stdin")
+		} else if c.includeFrom != "" || c.excludeFrom != "" {
+			return errors.New("--include-from and --exclude-from cannot be used when reading from stdin")
+		} else if c.progress {
+			return errors.New("--progress cannot be used when reading from stdin")
+		}
 		return nil
 	}
 
+	if c.recursive && !c.allowUnbounded {
+		if err := checkWalkBounds(args); err != nil {
+			return err
+		}
+	}
+
 	for _, p := range args {
 		fi, err := os.Stat(p)
 		if err != nil {
@@ -158,17 +914,34 @@ func (c *command) validateArgs(args []string) error {
 		}
 
 		if fi.IsDir() && !c.recursive {
-			fmt.Fprintf(os.Stderr, "error: %s is a directory\n\n", p)
-			return flag.ErrHelp
+			// without -r, a directory must directly contain a config
+			if _, err := os.Stat(filepath.Join(p, "terraform.tfvars")); err != nil && len(c.tfConfigs(p)) == 0 {
+				c.errorf("%s is a directory and does not contain a terraform.tfvars file. use -r to search subdirectories", p)
+				return flag.ErrHelp
+			}
 		}
 	}
 
 	return nil
 }
 
+// loadFiles returns the files to upgrade for the given arguments. Files named
+// explicitly are always upgraded, regardless of their name. Directories are
+// searched for terraform.tfvars files, and with --tf-files, .tf files with a
+// terragrunt attribute: only the directory itself without -r, or the
+// directory and all of its subdirectories with -r. A file is only returned
+// once, even if it is matched by more than one argument.
 func (c *command) loadFiles(args []string) ([]string, error) {
 	var files []string
 
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[filepath.Clean(path)] {
+			seen[filepath.Clean(path)] = true
+			files = append(files, path)
+		}
+	}
+
 	for _, p := range args {
 		if p == "-" {
 			return []string{"-"}, nil
@@ -181,7 +954,16 @@ func (c *command) loadFiles(args []string) ([]string, error) {
 
 		if fi.IsDir() {
 			if !c.recursive {
-				fmt.Fprintf(os.Stderr, "warning: recursive option not specified. ignoring directory %s\n", p)
+				tf := c.tfConfigs(p)
+				path := filepath.Join(p, "terraform.tfvars")
+				if _, err := os.Stat(path); err == nil {
+					add(path)
+				} else if len(tf) == 0 {
+					c.warnf("recursive option not specified and %s does not exist. ignoring directory %s", path, p)
+				}
+				for _, path := range tf {
+					add(path)
+				}
 				continue
 			}
 
@@ -190,12 +972,18 @@ func (c *command) loadFiles(args []string) ([]string, error) {
 					return err
 				}
 
-				if fi.IsDir() && fi.Name() == ".terragrunt-cache" {
+				if fi.IsDir() && c.excluded(path) {
+					return filepath.SkipDir
+				}
+
+				if fi.IsDir() && c.maxDepth > 0 && walkDepth(p, path) > c.maxDepth {
 					return filepath.SkipDir
 				}
 
 				if fi.Name() == "terraform.tfvars" {
-					files = append(files, path)
+					add(path)
+				} else if c.tfFiles && !fi.IsDir() && isTerragruntTF(path) {
+					add(path)
 				}
 
 				return nil
@@ -205,44 +993,824 @@ func (c *command) loadFiles(args []string) ([]string, error) {
 				return files, err
 			}
 		} else {
-			if fi.Name() != "terraform.tfvars" {
-				fmt.Fprintf(os.Stderr, "warning: ignoring file %s", p)
+			add(p)
+		}
+	}
+
+	if c.includeFrom != "" || c.excludeFrom != "" {
+		var include, exclude []string
+		for _, p := range []struct {
+			path     string
+			patterns *[]string
+		}{{c.includeFrom, &include}, {c.excludeFrom, &exclude}} {
+			if p.path == "" {
+				continue
+			}
+			patterns, err := readPatterns(p.path)
+			if err != nil {
+				return nil, err
+			}
+			*p.patterns = patterns
+		}
+
+		var filtered []string
+		for _, f := range files {
+			rel := relativePath(f)
+			if (c.includeFrom != "" && !matchesAny(include, rel)) || matchesAny(exclude, rel) {
+				c.debugf("skipping %s. it is filtered out by --include-from or --exclude-from", f)
+				// it may still be included by one that isn't
+				delete(seen, filepath.Clean(f))
 				continue
 			}
-			files = append(files, p)
+			filtered = append(filtered, f)
 		}
+		files = filtered
 	}
 
-	return files, nil
-}
+	if c.since != "" {
+		changed, err := changedFiles(c.since)
+		if err != nil {
+			return nil, err
+		}
 
-func (c *command) readFile(path string) ([]byte, error) {
-	if path == "-" {
-		return ioutil.ReadAll(os.Stdin)
+		var filtered []string
+		for _, f := range files {
+			if changed[resolvedPath(f)] {
+				filtered = append(filtered, f)
+			} else {
+				c.debugf("skipping %s. it has not changed since %s", f, c.since)
+				// it may still be included by one that has
+				delete(seen, filepath.Clean(f))
+			}
+		}
+		files = filtered
+	}
+
+	if c.resolveIncludes {
+		// a parent is upgraded along with a child that changed, even if
+		// the parent didn't. parents found this way are checked for
+		// includes of their own
+		for i := 0; i < len(files); i++ {
+			if parent := c.includedConfig(files[i]); parent != "" {
+				add(parent)
+			}
+		}
+	}
+
+	if c.merge {
+		files = c.dropFragments(files)
 	}
-	return ioutil.ReadFile(path)
+
+	return files, nil
 }
 
-// upgrade reads in a terragrunt <= 0.18 config (hcl v1 syntax) and returns
-// and upgraded terragrunt >= 0.19 configuration in hcl v2 syntax.
-func (c *command) upgrade(input []byte) ([]byte, error) {
-	res, err := hclv1parser.Parse(input)
+// readPatterns reads the glob patterns in the file at path, for
+// --include-from or --exclude-from. Each line holds one pattern, with
+// forward slashes between directories. Blank lines, and lines starting with
+// #, are ignored.
+func readPatterns(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing file: %v", err)
+		return nil, err
 	}
 
-	var (
-		tgSettings []*hclv1ast.ObjectItem
-		inputVars  []*hclv1ast.ObjectItem
-	)
+	var patterns []string
+	for i, line := range strings.Split(string(normalizeInput(data)), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
 
-	detachedComments := c.loadDetachedComments(res)
+		pattern := filepath.Clean(filepath.FromSlash(line))
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q: %v", path, i+1, line, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
 
-	root := res.Node.(*hclv1ast.ObjectList)
-	for _, item := range root.Items {
+// matchesAny reports whether any of patterns matches path or one of the
+// directories containing it, so that a pattern can name a module's
+// directory rather than its config.
+func matchesAny(patterns []string, path string) bool {
+	for p := path; ; p = filepath.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, p); ok {
+				return true
+			}
+		}
+		if d := filepath.Dir(p); d == p || d == "." {
+			return false
+		}
+	}
+}
+
+// relativePath returns path relative to the current directory, or path
+// itself if it can't be made relative.
+func relativePath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	rel, err := filepath.Rel(wd, absPath(path))
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return rel
+}
+
+// findInParentFolders matches an include path that is nothing but a call to
+// find_in_parent_folders, capturing the name of the file it looks for.
+var findInParentFolders = regexp.MustCompile(`^\$\{find_in_parent_folders\(\s*(?:"([^"]*)"\s*(?:,\s*"[^"]*"\s*)?)?\)\}$`)
+
+// includedConfig returns the path of the parent config that the config at
+// path includes, for --resolve-includes. The path in the include block is
+// resolved the way terragrunt v0.18 did if it's a plain path or a call to
+// find_in_parent_folders. Any other include is only reported, and "" is
+// returned, as it is for a config without an include.
+func (c *command) includedConfig(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		// reported when the config is upgraded
+		return ""
+	}
+
+	res, err := hclv1parser.Parse(normalizeInput(data))
+	if err != nil {
+		return ""
+	}
+
+	var val *hclv1ast.LiteralType
+	for _, tg := range res.Node.(*hclv1ast.ObjectList).Filter("terragrunt").Items {
+		tgObj, ok := tg.Val.(*hclv1ast.ObjectType)
+		if !ok {
+			continue
+		}
+		for _, inc := range tgObj.List.Filter("include").Items {
+			incObj, ok := inc.Val.(*hclv1ast.ObjectType)
+			if !ok {
+				continue
+			}
+			for _, item := range incObj.List.Filter("path").Items {
+				if lit, ok := item.Val.(*hclv1ast.LiteralType); ok && lit.Token.Type == hclv1token.STRING {
+					val = lit
+				}
+			}
+		}
+	}
+	if val == nil {
+		return ""
+	}
+
+	include := val.Token.Value().(string)
+	dir := filepath.Dir(path)
+	if m := findInParentFolders.FindStringSubmatch(include); m != nil {
+		name := m[1]
+		if name == "" {
+			name = "terraform.tfvars"
+		}
+
+		// like terragrunt, start from the parent of the config's directory.
+		// the absolute path only tells when the root has been searched, so
+		// a relative path stays relative
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return ""
+		}
+		for d := filepath.Join(dir, ".."); abs != filepath.Dir(abs); d = filepath.Join(d, "..") {
+			abs = filepath.Dir(abs)
+			parent := filepath.Join(d, name)
+			if _, err := os.Stat(parent); err == nil {
+				c.debugf("%s includes %s", path, parent)
+				return parent
+			}
+		}
+		c.warnf("could not find %s in the parent directories of %s, so the config it includes will not be upgraded", name, path)
+		return ""
+	} else if strings.Contains(include, "${") {
+		c.warnf("cannot resolve the include path %s in %s. upgrade the config it includes separately", include, path)
+		return ""
+	}
+
+	parent := include
+	if !filepath.IsAbs(parent) {
+		parent = filepath.Join(dir, parent)
+	}
+	if _, err := os.Stat(parent); err != nil {
+		c.warnf("%s includes %s, which does not exist, so it will not be upgraded", path, parent)
+		return ""
+	}
+	c.debugf("%s includes %s", path, parent)
+	return parent
+}
+
+// tfConfigs returns the .tf files directly in dir that contain a terragrunt
+// attribute, if --tf-files is set.
+func (c *command) tfConfigs(dir string) []string {
+	if !c.tfFiles {
+		return nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil
+	}
+
+	var configs []string
+	for _, m := range matches {
+		if isTerragruntTF(m) {
+			configs = append(configs, m)
+		}
+	}
+	return configs
+}
+
+// isTerragruntTF reports whether path is a .tf file that parses as hcl v1
+// and has a top-level terragrunt attribute. Most .tf files are plain
+// terraform, which may not even parse as hcl v1, so they're not upgraded.
+func isTerragruntTF(path string) bool {
+	if filepath.Ext(path) != ".tf" {
+		return false
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	res, err := hclv1parser.Parse(normalizeInput(data))
+	if err != nil {
+		return false
+	}
+
+	for _, item := range res.Node.(*hclv1ast.ObjectList).Items {
+		if item.Keys[0].Token.Text == "terragrunt" {
+			return true
+		}
+	}
+	return false
+}
+
+// dropFragments removes any fragments from files that will be merged into
+// another file in the list, so they aren't also upgraded on their own.
+func (c *command) dropFragments(files []string) []string {
+	primaries := make(map[string]bool)
+	for _, f := range files {
+		if filepath.Base(f) == "terraform.tfvars" {
+			primaries[filepath.Clean(filepath.Dir(f))] = true
+		}
+	}
+
+	var ret []string
+	for _, f := range files {
+		if filepath.Base(f) != "terraform.tfvars" && filepath.Ext(f) == ".tfvars" && primaries[filepath.Clean(filepath.Dir(f))] {
+			c.debugf("%s will be merged into %s", f, filepath.Join(filepath.Dir(f), "terraform.tfvars"))
+			continue
+		}
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+// fragmentFiles returns the other .tfvars files in the same directory as
+// path, in lexical order, if path is a terraform.tfvars file.
+func fragmentFiles(path string) ([]string, error) {
+	if path == "-" || filepath.Base(path) != "terraform.tfvars" {
+		return nil, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tfvars"))
+	if err != nil {
+		return nil, err
+	}
+
+	var fragments []string
+	for _, m := range matches {
+		if filepath.Base(m) != "terraform.tfvars" {
+			fragments = append(fragments, m)
+		}
+	}
+	return fragments, nil
+}
+
+// fragment is a .tfvars file whose input variables are merged into the
+// terraform.tfvars file in the same directory.
+type fragment struct {
+	path     string
+	contents []byte
+}
+
+// mergedSource records the line of a merged config where the contents of a
+// fragment begin, so positions can be reported in the fragment itself.
+type mergedSource struct {
+	path string
+	line int
+}
+
+// mergeFragments appends the contents of fragments to primary, as if their
+// input variables had been written at the end of it, and returns where each
+// fragment begins. Fragments may only contain input variables, and a
+// variable may not be set in more than one of the files.
+func mergeFragments(primaryPath string, primary []byte, fragments []fragment) ([]byte, []mergedSource, error) {
+	seen := make(map[string]string)
+	check := func(path string, contents []byte, isPrimary bool) error {
+		f, err := hclv1parser.Parse(contents)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %v", path, err)
+		}
+
+		for _, item := range f.Node.(*hclv1ast.ObjectList).Items {
+			key := strings.Trim(item.Keys[0].Token.Text, `"`)
+			if key == "terragrunt" {
+				if isPrimary {
+					continue
+				}
+				return fmt.Errorf("%w: %s contains terragrunt settings, which may only be in %s", errMergeConflict, path, primaryPath)
+			}
+
+			if other, ok := seen[key]; ok {
+				return fmt.Errorf("%w: %s is set in both %s and %s", errMergeConflict, key, other, path)
+			}
+			seen[key] = path
+		}
+		return nil
+	}
+
+	if err := check(primaryPath, primary, true); err != nil {
+		return nil, nil, err
+	}
+
+	var sources []mergedSource
+	merged := append([]byte{}, primary...)
+	for _, f := range fragments {
+		if err := check(f.path, f.contents, false); err != nil {
+			return nil, nil, err
+		}
+		merged = append(merged, '\n')
+		sources = append(sources, mergedSource{path: f.path, line: bytes.Count(merged, []byte{'\n'}) + 1})
+		merged = append(merged, f.contents...)
+	}
+	return merged, sources, nil
+}
+
+// logLevel controls which messages are printed. Each level includes the
+// levels before it.
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+var logLevels = map[string]logLevel{
+	"error": levelError,
+	"warn":  levelWarn,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+// level returns the level of messages to print: the --log-level if given,
+// otherwise error with --quiet, debug with --verbose, or info.
+func (c *command) level() logLevel {
+	if l, ok := logLevels[c.logLevel]; ok {
+		return l
+	} else if c.quiet {
+		return levelError
+	} else if c.verbose {
+		return levelDebug
+	}
+	return levelInfo
+}
+
+// logf prints a message at the given level if it is enabled. Informational
+// messages are printed to stdout, and everything else is printed to stderr
+// prefixed with its level.
+func (c *command) logf(level logLevel, format string, args ...interface{}) {
+	if level > c.level() {
+		return
+	}
+
+	c.clearProgress()
+	switch level {
+	case levelError:
+		fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+	case levelWarn:
+		fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
+	case levelInfo:
+		fmt.Printf(format+"\n", args...)
+	case levelDebug:
+		fmt.Fprintf(os.Stderr, "debug: "+format+"\n", args...)
+	}
+}
+
+// errorf prints an error that doesn't stop processing to stderr. Errors are
+// printed at every log level.
+func (c *command) errorf(format string, args ...interface{}) {
+	c.logf(levelError, format, args...)
+}
+
+// warnf prints a warning to stderr unless the log level is error.
+func (c *command) warnf(format string, args ...interface{}) {
+	c.warnings++
+	c.logf(levelWarn, format, args...)
+}
+
+// configWarnf prints a warning about a line of the config currently being
+// upgraded, prefixed with its location.
+func (c *command) configWarnf(line int, format string, args ...interface{}) {
+	path, line := c.sourceLine(line)
+	if path == "" {
+		path = c.file
+	}
+	loc := fmt.Sprintf("line %d", line)
+	if path != "" {
+		loc = fmt.Sprintf("%s:%d", path, line)
+	}
+	c.warnf("%s: "+format, append([]interface{}{loc}, args...)...)
+}
+
+// configErrorf returns an error about a line of the config currently being
+// upgraded. The config is named by the caller, so the location is only a
+// line number, unless the line was merged from a fragment.
+func (c *command) configErrorf(line int, format string, args ...interface{}) error {
+	path, line := c.sourceLine(line)
+	loc := fmt.Sprintf("line %d", line)
+	if path != "" {
+		loc = fmt.Sprintf("%s:%d", path, line)
+	}
+	return fmt.Errorf("%s: "+format, append([]interface{}{loc}, args...)...)
+}
+
+// sourceLine translates a line of the config currently being upgraded to a
+// line of the fragment it was merged from. path is empty if the line is in
+// the config itself.
+func (c *command) sourceLine(line int) (path string, sourceLine int) {
+	for i := len(c.sources) - 1; i >= 0; i-- {
+		if s := c.sources[i]; line >= s.line {
+			return s.path, line - s.line + 1
+		}
+	}
+	return "", line
+}
+
+// infof prints an informational message to stdout if the log level is info
+// or debug.
+func (c *command) infof(format string, args ...interface{}) {
+	c.logf(levelInfo, format, args...)
+}
+
+// debugf prints a message describing a decision made while upgrading a
+// config to stderr if the log level is debug.
+func (c *command) debugf(format string, args ...interface{}) {
+	c.logf(levelDebug, format, args...)
+}
+
+// checkWalkBounds returns an error if any of the directories in args are
+// outside of the git repository containing the current directory. This
+// keeps a mistake like "-r /" from walking the entire filesystem.
+func checkWalkBounds(args []string) error {
+	root, err := gitRoot(".")
+	if err != nil {
+		return fmt.Errorf("-r can only search inside of a git repository unless --allow-unbounded is specified: %v", err)
+	}
+
+	for _, p := range args {
+		if fi, err := os.Stat(p); err != nil || !fi.IsDir() {
+			continue
+		}
+
+		ok, err := within(root, p)
+		if err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%s is outside of the git repository at %s. use --allow-unbounded to search it anyway", p, root)
+		}
+	}
+
+	return nil
+}
+
+// gitRoot returns the root of the git repository containing dir.
+func gitRoot(dir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// within returns true if path is root or a directory below it.
+func within(root, path string) (bool, error) {
+	var err error
+	for _, p := range []*string{&root, &path} {
+		// resolve symlinks, so that e.g. /tmp and /private/tmp on macOS
+		// are treated the same
+		if *p, err = filepath.Abs(*p); err != nil {
+			return false, err
+		}
+		if *p, err = filepath.EvalSymlinks(*p); err != nil {
+			return false, err
+		}
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false, nil
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+var defaultExcludeDirs = []string{".terragrunt-cache", ".terraform"}
+
+// configFile is the file in the current directory that default flag values
+// are read from, if it exists.
+const configFile = ".terragrunt-upgrade.hcl"
+
+// flagGroups are flags that choose between alternatives. Setting one of them
+// on the command line overrides the config file's values for all of them,
+// rather than conflicting with them.
+var flagGroups = [][]string{
+	{"quiet", "verbose", "log-level"},
+	{"indent", "no-format"},
+	{"dry-run", "stdout", "output-stdout-json", "in-place", "git-mv", "preview-dir", "concat", "diff-only-changed"},
+}
+
+// loadDefaults sets the flags in fs that weren't given on the command line to
+// the values of the attributes in the config file at path, which are named
+// after the flags, e.g.:
+//
+//	recursive       = true
+//	exclude-dir     = [".terragrunt-cache", ".terraform", "vendor"]
+//	rename-function = { get_account = "get_aws_account_id" }
+//
+// A list replaces the default values of a flag that may be repeated, and an
+// object sets it to key=value pairs. fs must already have been parsed.
+func loadDefaults(fs *flag.FlagSet, path string) error {
+	src, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	f, diags := hclv2syntax.ParseConfig(src, path, hclv2.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return diags
+	}
+	attrs, diags := f.Body.JustAttributes()
+	if diags.HasErrors() {
+		return diags
+	}
+
+	// aliases like -q and --quiet share a value, so flags are identified by
+	// the value they set
+	set := make(map[flag.Value]bool)
+	fs.Visit(func(fl *flag.Flag) {
+		set[fl.Value] = true
+	})
+	for _, group := range flagGroups {
+		var given bool
+		for _, name := range group {
+			if fl := fs.Lookup(name); fl != nil && set[fl.Value] {
+				given = true
+			}
+		}
+		for _, name := range group {
+			if fl := fs.Lookup(name); fl != nil && given {
+				set[fl.Value] = true
+			}
+		}
+	}
+
+	// attributes are applied in the order they're written, so errors are
+	// reported consistently
+	var names []string
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return attrs[names[i]].Range.Start.Byte < attrs[names[j]].Range.Start.Byte
+	})
+
+	for _, name := range names {
+		attr := attrs[name]
+		fl := fs.Lookup(name)
+		if fl == nil {
+			return fmt.Errorf("%s:%d: unknown flag %s", path, attr.Range.Start.Line, name)
+		}
+
+		var values []string
+		if pairs, mdiags := hclv2.ExprMap(attr.Expr); !mdiags.HasErrors() {
+			for _, pair := range pairs {
+				var k, v string
+				if diags = hclv2gohcl.DecodeExpression(pair.Key, nil, &k); diags.HasErrors() {
+					return diags
+				}
+				if diags = hclv2gohcl.DecodeExpression(pair.Value, nil, &v); diags.HasErrors() {
+					return diags
+				}
+				values = append(values, k+"="+v)
+			}
+		} else if _, ldiags := hclv2.ExprList(attr.Expr); ldiags.HasErrors() {
+			var v string
+			diags = hclv2gohcl.DecodeExpression(attr.Expr, nil, &v)
+			values = append(values, v)
+		} else {
+			diags = hclv2gohcl.DecodeExpression(attr.Expr, nil, &values)
+		}
+		if diags.HasErrors() {
+			return diags
+		}
+
+		if set[fl.Value] {
+			continue
+		}
+		if sl, ok := fl.Value.(*stringList); ok {
+			sl.values = values
+			continue
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("%s:%d: invalid value %q for flag %s: %v", path, attr.Range.Start.Line, v, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// excluded returns true if the directory at path should be skipped when
+// recursing. A directory is excluded if its path ends with one of the
+// excluded directories, e.g. "vendor" or "modules/vendor".
+func (c *command) excluded(path string) bool {
+	exclude := c.excludeDirs.values
+	if !c.excludeDirs.set && exclude == nil {
+		exclude = defaultExcludeDirs
+	}
+
+	path = filepath.Clean(path)
+	for _, e := range exclude {
+		e = filepath.Clean(e)
+		if path == e || strings.HasSuffix(path, string(filepath.Separator)+e) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkDepth returns the number of directories between root and path.
+func walkDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+func (c *command) readFile(path string) ([]byte, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if path == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(b, gzipMagic) {
+		// e.g. a terraform.tfvars.gz backup. the upgraded config is
+		// written uncompressed.
+		if b, err = gunzip(b); err != nil {
+			return nil, fmt.Errorf("error decompressing %s: %v", path, err)
+		}
+	}
+	return normalizeInput(b), nil
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gunzip decompresses gzip-compressed data.
+func gunzip(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// normalizeInput strips a leading UTF-8 byte order mark and converts CRLF
+// line endings to LF, so that configs written on Windows parse the same way
+// and the upgraded config doesn't end up with a mix of line endings.
+func normalizeInput(b []byte) []byte {
+	b = bytes.TrimPrefix(b, utf8BOM)
+	return bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+}
+
+// mergeFile merges the fragments next to the config at path into its
+// contents, orig, and returns the merged contents along with the paths of
+// the fragments. Positions in the merged contents are reported in the
+// fragments they came from.
+func (c *command) mergeFile(path string, orig []byte) ([]byte, []string, error) {
+	paths, err := fragmentFiles(path)
+	if err != nil || len(paths) == 0 {
+		return orig, nil, err
+	}
+
+	var fragments []fragment
+	for _, p := range paths {
+		b, err := c.readFile(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		fragments = append(fragments, fragment{path: p, contents: b})
+		c.debugf("merging %s into %s", p, path)
+	}
+
+	merged, sources, err := mergeFragments(path, orig, fragments)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.sources = sources
+	return merged, paths, nil
+}
+
+// parseError explains errors parsing input caused by JSON syntax, which the
+// hcl v1 parser only accepts in .json files.
+func parseError(input []byte, err error) error {
+	if bytes.HasPrefix(bytes.TrimSpace(input), []byte("{")) {
+		return errJSONInput
+	}
+
+	var pe *hclv1parser.PosError
+	if errors.As(err, &pe) && pe.Pos.Offset < len(input) && input[pe.Pos.Offset] == ':' {
+		return fmt.Errorf("error parsing file: %v. use \"key = value\" instead of the JSON-style \"key: value\"", err)
+	}
+	return fmt.Errorf("error parsing file: %v", err)
+}
+
+// upgrade reads in a terragrunt <= 0.18 config (hcl v1 syntax) and returns
+// and upgraded terragrunt >= 0.19 configuration in hcl v2 syntax. If the
+// upgraded configuration is not valid hcl v2, the returned error wraps
+// errInvalidOutput.
+func (c *command) upgrade(input []byte) ([]byte, error) {
+	rules, err := c.renderRules()
+	if err != nil {
+		return nil, err
+	}
+	c.rules = rules
+	if c.funcs, err = c.funcUpgrades(); err != nil {
+		return nil, err
+	}
+	c.stats = upgradeStats{}
+	c.writeErr = nil
+
+	input = normalizeInput(input)
+	res, err := hclv1parser.Parse(input)
+	if err != nil {
+		return nil, parseError(input, err)
+	}
+
+	var (
+		tgFound    bool
+		tgPrev     hclv1token.Pos
+		tgEnd      hclv1token.Pos
+		tgSettings []*hclv1ast.ObjectItem
+		inputVars  []*hclv1ast.ObjectItem
+	)
+
+	detachedComments := c.loadDetachedComments(res)
+
+	root := res.Node.(*hclv1ast.ObjectList)
+	for _, item := range root.Items {
 		item := item
 		if item.Keys[0].Token.Text == "terragrunt" {
+			// there's no terragrunt block in the upgraded config for
+			// these to be attached to
+			for _, cg := range []*hclv1ast.CommentGroup{item.LeadComment, item.LineComment} {
+				if cg != nil {
+					c.lossf(cg.Pos().Line, "dropping comment %q on the terragrunt attribute", cg.List[0].Text)
+				}
+			}
+
+			if len(inputVars) > 0 {
+				tgPrev = endPos(inputVars[len(inputVars)-1].Val)
+			}
+
+			tgFound = true
 			obj := item.Val.(*hclv1ast.ObjectType)
+			tgEnd = obj.Rbrace
 			for _, o := range obj.List.Items {
 				tgSettings = append(tgSettings, o)
 			}
@@ -251,92 +1819,402 @@ func (c *command) upgrade(input []byte) ([]byte, error) {
 		}
 	}
 
-	if len(tgSettings) == 0 {
+	if !tgFound {
 		return nil, errNotTerragruntConfig
 	}
 
+	if c.dumpAST {
+		dumpAST(os.Stderr, tgSettings, inputVars, detachedComments)
+	}
+
+	for _, pos := range nestedTerragruntKeys(root) {
+		c.configWarnf(pos.Line, "found a terragrunt key below the top level of the config. it will be upgraded as a regular value, which is probably not correct")
+	}
+
+	if c.noInputsBlock {
+		for _, item := range collidingInputs(inputVars) {
+			c.configWarnf(item.Pos().Line, "input variable %s has the same name as a terragrunt setting, so terragrunt will reject it as a top-level attribute. upgrade this config without --no-inputs-block", strings.Trim(item.Keys[0].Token.Text, `"`))
+		}
+
+		// hcl v1 allows periods in identifiers, so tags.Name is a single
+		// variable rather than a path. it can be quoted in the inputs
+		// block, but not as a top-level attribute
+		for _, item := range inputVars {
+			if key := item.Keys[0].Token; key.Type == hclv1token.IDENT && needsQuoting(key.Text) {
+				c.configWarnf(item.Pos().Line, "input variable %s is not a valid hcl v2 identifier, so it can't be written as a top-level attribute. upgrade this config without --no-inputs-block", key.Text)
+			}
+		}
+	}
+
+	if only := c.onlySettings(); only != nil {
+		var kept []*hclv1ast.ObjectItem
+		for _, item := range tgSettings {
+			key := item.Keys[0].Token.Text
+			if only[key] {
+				kept = append(kept, item)
+			} else {
+				c.configWarnf(item.Pos().Line, "dropping %s. it is not listed in --only", key)
+			}
+		}
+		tgSettings = kept
+	}
+
+	tgSettings = c.dropRemovedSettings(tgSettings, input, detachedComments)
+
 	f := hclv2write.NewEmptyFile()
 	body := f.Body()
 
-	c.writeNode(-1, "", body, &hclv1ast.ObjectList{Items: tgSettings}, detachedComments)
+	if c.addMigrationNote {
+		body.AppendUnstructuredTokens(hclv2write.Tokens{tokComment(migrationNote(time.Now()))})
+		body.AppendNewline()
+	}
+
+	// When input variables come before the terragrunt attribute, the
+	// comments at the top of the file and those leading up to and inside
+	// of the terragrunt attribute are kept with the settings, which are
+	// moved above the inputs. Everything else stays with the inputs.
+	tgComments := detachedComments
+	if tgPrev.IsValid() {
+		tgComments = &commentList{}
+		*tgComments = append(*tgComments, detachedComments.PopBefore(root.Items[0].Pos())...)
+		*tgComments = append(*tgComments, detachedComments.PopBetween(tgPrev, tgEnd)...)
+	}
+
+	if len(tgSettings) > 0 {
+		c.writeNode("", body, &hclv1ast.ObjectList{Items: tgSettings}, tgComments)
+	}
+
+	if tgComments != detachedComments {
+		c.writeComments(body, *tgComments)
+	}
 
 	if len(inputVars) > 0 {
-		body.AppendNewline()
-		inputs := &hclv1ast.ObjectItem{
-			Keys: []*hclv1ast.ObjectKey{
-				{
-					Token: hclv1token.Token{
-						Type: hclv1token.IDENT,
-						Pos:  inputVars[0].Pos(),
-						Text: "inputs",
+		if len(tgSettings) > 0 {
+			body.AppendNewline()
+		}
+
+		// Position the inputs attribute directly after the terragrunt
+		// attribute, so that detached comments between the two are
+		// written inside of the inputs block, above the first variable.
+		pos := inputVars[0].Pos()
+		if tgEnd.Before(pos) {
+			pos = tgEnd
+		}
+
+		if c.sortInputs {
+			c.writeSortedInputs(body, inputVars, detachedComments)
+		} else if c.noInputsBlock {
+			// The variables are still written under the inputs path, so
+			// that none of them are mistaken for blocks
+			c.writeNode("inputs", body, &hclv1ast.ObjectList{Items: inputVars}, detachedComments)
+		} else {
+			inputs := &hclv1ast.ObjectItem{
+				Keys: []*hclv1ast.ObjectKey{
+					{
+						Token: hclv1token.Token{
+							Type: hclv1token.IDENT,
+							Pos:  pos,
+							Text: "inputs",
+						},
 					},
 				},
-			},
-			Val: &hclv1ast.ObjectType{
-				List: &hclv1ast.ObjectList{
-					Items: inputVars,
+				Val: &hclv1ast.ObjectType{
+					List: &hclv1ast.ObjectList{
+						Items: inputVars,
+					},
 				},
-			},
+			}
+
+			c.writeNode("", body, inputs, detachedComments)
 		}
+	}
+
+	// write out any remaining comments, each separated from whatever comes
+	// before it by exactly one blank line
+	c.writeComments(body, *detachedComments)
 
-		c.writeNode(-1, "", body, inputs, detachedComments)
+	if c.writeErr != nil {
+		return nil, c.writeErr
 	}
 
-	if detachedComments.Len() > 0 {
-		// write out any remaining comments
-		for _, cg := range *detachedComments {
-			body.AppendNewline()
-			c.writeNode(0, "", body, cg, nil)
+	indent, err := c.indentation()
+	if err != nil {
+		return nil, err
+	}
+
+	out := f.Bytes()
+	if c.noFormat {
+		// the tokens as they were written, for debugging the formatting
+		c.debugf("skipping formatting")
+	} else {
+		out = hclv2write.Format(out)
+		if indent != defaultIndent {
+			out = reindent(out, indent)
+		}
+	}
+
+	// always end the file with exactly one newline
+	out = append(bytes.TrimRight(out, "\n"), '\n')
+
+	// check the new config before anything is written to disk
+	p := hclv2parse.NewParser()
+	_, diags := p.ParseHCL(out, "terragrunt.hcl")
+	if diags.HasErrors() {
+		return nil, &invalidOutputError{diags: diags, files: p.Files()}
+	}
+
+	return out, nil
+}
+
+// defaultIndent is the indentation applied to each level of nesting by
+// hclv2write.Format. This is not configurable.
+const defaultIndent = "  "
+
+// onlySettings returns the set of terragrunt settings listed in --only, or
+// nil if every setting should be upgraded.
+func (c *command) onlySettings() map[string]bool {
+	if c.only == "" {
+		return nil
+	}
+
+	only := make(map[string]bool)
+	for _, s := range strings.Split(c.only, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			only[s] = true
+		}
+	}
+	return only
+}
+
+// sourceRewrite replaces a prefix of terraform.source, for --rewrite-sources.
+type sourceRewrite struct {
+	prefix, replacement string
+}
+
+// sourceRewrites returns the --rewrite-sources mappings, longest prefix
+// first, so the most specific one is used.
+func (c *command) sourceRewrites() ([]sourceRewrite, error) {
+	var rewrites []sourceRewrite
+	for _, v := range c.rewriteSources.values {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid --rewrite-sources %q. must be prefix=replacement", v)
+		}
+		rewrites = append(rewrites, sourceRewrite{prefix: v[:i], replacement: v[i+1:]})
+	}
+
+	sort.SliceStable(rewrites, func(i, j int) bool {
+		return len(rewrites[i].prefix) > len(rewrites[j].prefix)
+	})
+	return rewrites, nil
+}
+
+// rewriteSource returns val, the value of terraform.source, with its prefix
+// replaced by the first matching --rewrite-sources mapping. The prefix is
+// matched against the string as it's written, including any interpolations.
+func (c *command) rewriteSource(val *hclv1ast.LiteralType) *hclv1ast.LiteralType {
+	rewrites, _ := c.sourceRewrites()
+	source := strings.TrimSuffix(strings.TrimPrefix(val.Token.Text, `"`), `"`)
+	for _, r := range rewrites {
+		if !strings.HasPrefix(source, r.prefix) {
+			continue
+		}
+
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(r.replacement)
+		rewritten := escaped + source[len(r.prefix):]
+		c.debugf("line %d: rewriting source %s to %s", val.Pos().Line, source, rewritten)
+
+		v := *val
+		v.Token.Text = `"` + rewritten + `"`
+		return &v
+	}
+	return val
+}
+
+// boolSettings lists the terragrunt settings that are booleans. terragrunt
+// v0.18 accepted a string such as "true" for them, but v0.19 doesn't.
+var boolSettings = map[string]bool{
+	"prevent_destroy": true,
+	"skip":            true,
+}
+
+// upgradeBoolString returns val, a string given for one of boolSettings, as a
+// bool. Strings are parsed the same way hcl v1 decoded them into a bool. A
+// string that isn't a bool, e.g. an interpolation, is returned unchanged.
+func (c *command) upgradeBoolString(val *hclv1ast.LiteralType) *hclv1ast.LiteralType {
+	s, ok := val.Token.Value().(string)
+	if !ok {
+		return val
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return val
+	}
+	c.debugf("line %d: converting string %s to bool %t", val.Pos().Line, val.Token.Text, b)
+
+	v := *val
+	v.Token.Type = hclv1token.BOOL
+	v.Token.Text = strconv.FormatBool(b)
+	return &v
+}
+
+// indentation returns the string used to indent each level of nesting
+// in the upgraded configuration.
+func (c *command) indentation() (string, error) {
+	switch c.indent {
+	case "":
+		return defaultIndent, nil
+	case "tab":
+		return "\t", nil
+	}
+
+	n, err := strconv.Atoi(c.indent)
+	if err != nil || n < 1 {
+		return "", fmt.Errorf("invalid indent %q: must be a positive number of spaces or \"tab\"", c.indent)
+	}
+	return strings.Repeat(" ", n), nil
+}
+
+// reindent replaces the indentation of a config formatted by hclv2write.Format
+// with indent. hclwrite always indents with two spaces per level of nesting
+// and can't be configured otherwise, so this is done as a separate pass over
+// the formatted output. Lines within heredocs and multi-line comments are left
+// untouched, as changing their whitespace could change their meaning.
+func reindent(src []byte, indent string) []byte {
+	tokens, diags := hclv2syntax.LexConfig(src, "", hclv2.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return src
+	}
+
+	verbatim := make(map[int]bool)
+	heredoc := false
+	for _, t := range tokens {
+		switch {
+		case t.Type == hclv2syntax.TokenOHeredoc:
+			heredoc = true
+		case t.Type == hclv2syntax.TokenCHeredoc:
+			heredoc = false
+			verbatim[t.Range.Start.Line] = true
+		case heredoc:
+			verbatim[t.Range.Start.Line] = true
+		case t.Type == hclv2syntax.TokenComment:
+			for l := t.Range.Start.Line + 1; l <= t.Range.End.Line; l++ {
+				if l == t.Range.End.Line && t.Range.End.Column == 1 {
+					// line comments include their trailing newline
+					break
+				}
+				verbatim[l] = true
+			}
 		}
 	}
 
-	return hclv2write.Format(f.Bytes()), nil
+	lines := bytes.SplitAfter(src, []byte{'\n'})
+	for i, line := range lines {
+		if verbatim[i+1] {
+			continue
+		}
+
+		trimmed := bytes.TrimLeft(line, " ")
+		depth := (len(line) - len(trimmed)) / len(defaultIndent)
+		lines[i] = append([]byte(strings.Repeat(indent, depth)), trimmed...)
+	}
+
+	return bytes.Join(lines, nil)
 }
 
-func (c *command) writeNode(depth int, parentKey string, body *hclv2write.Body, node hclv1ast.Node, cl *commentList) {
+// writeNode writes node to body. parent is the path to the setting that
+// contains node, e.g. "terraform.extra_arguments", and is empty for
+// settings at the top level of the config.
+func (c *command) writeNode(parent string, body *hclv2write.Body, node hclv1ast.Node, cl *commentList) {
 	// write out any detached comments that should come before the current node
 	if cl != nil {
-		comments := cl.PopBefore(node.Pos())
-		c.writeComments(body, comments)
+		if comments := cl.PopBefore(node.Pos()); len(comments) > 0 {
+			c.writeComments(body, comments)
+			body.AppendNewline()
+		}
 	}
 
 	switch nv := node.(type) {
 	case *hclv1ast.ListType:
 		oneline := nv.Lbrack.Line == nv.Rbrack.Line
+		if len(nv.List) == 0 && (cl == nil || len(cl.PeekBefore(nv.Rbrack)) == 0) {
+			// an empty list is written as [], however it was written
+			oneline = true
+		}
 		body.AppendUnstructuredTokens(hclv2write.Tokens{tokOBracket})
 		if !oneline {
 			body.AppendNewline()
 		}
 
 		for i, n := range nv.List {
-			if i > 0 {
+			if i > 0 && oneline {
+				// hclwrite adds the space after the comma when the tokens
+				// are written out, even with --no-format
+				body.AppendUnstructuredTokens(hclv2write.Tokens{tokComma})
+			}
+
+			// In a multi-line list, the comma following an element has to
+			// be written before the element's line comment
+			var lineComment *hclv1ast.CommentGroup
+			if lit, ok := n.(*hclv1ast.LiteralType); ok && !oneline && lit.LineComment != nil {
+				l := *lit
+				lineComment, l.LineComment = l.LineComment, nil
+				n = &l
+			}
+
+			c.writeNode(parent, body, n, cl)
+
+			if !oneline {
+				// if it's not a single-line list, every element is
+				// followed by a comma, including the last
 				body.AppendUnstructuredTokens(hclv2write.Tokens{tokComma})
-				if !oneline {
+				if lineComment != nil {
+					// writing the comment group ends the line
+					c.writeNode(parent, body, lineComment, nil)
+				} else {
 					body.AppendNewline()
 				}
 			}
-			c.writeNode(depth+1, parentKey, body, n, cl)
 		}
 
-		if !oneline {
-			// if it's not a single-line list, add a trailing comma
-			body.AppendUnstructuredTokens(hclv2write.Tokens{tokComma, tokNewline})
+		// detached comments at the end of the list stay inside of it
+		if cl != nil && !oneline {
+			c.writeComments(body, cl.PopBefore(nv.Rbrack))
 		}
-
 		body.AppendUnstructuredTokens(hclv2write.Tokens{tokCBracket})
 	case *hclv1ast.LiteralType:
 		if nv.LeadComment != nil {
-			c.writeNode(depth, parentKey, body, nv.LeadComment, nil)
+			c.writeNode(parent, body, nv.LeadComment, nil)
 		}
 
+		if parent == "terraform.source" && nv.Token.Type == hclv1token.STRING {
+			nv = c.rewriteSource(nv)
+		}
+		if boolSettings[parent] && nv.Token.Type == hclv1token.STRING {
+			nv = c.upgradeBoolString(nv)
+		}
 		c.writeLiteral(body, nv)
 
 		if nv.LineComment != nil {
-			c.writeNode(depth, parentKey, body, nv.LineComment, nil)
+			c.writeNode(parent, body, nv.LineComment, nil)
 		}
 	case *hclv1ast.ObjectItem:
 		if nv.LeadComment != nil {
-			c.writeNode(depth, parentKey, body, nv.LeadComment, nil)
+			c.writeNode(parent, body, nv.LeadComment, nil)
+		}
+
+		// comments between the key and the value, e.g. "foo = /* bar */ 1",
+		// are moved above the key, since a comment can't be written there
+		// in hcl v2 without breaking the line
+		var between commentList
+		if cl != nil {
+			between = cl.PopBefore(nv.Val.Pos())
+		}
+		if cg := leadingLineComment(nv.Val); cg != nil {
+			between = append(between, cg)
+		}
+		for _, cg := range between {
+			c.writeNode(parent, body, cg, nil)
 		}
 
 		key := nv.Keys[0].Token.Text
@@ -344,33 +2222,81 @@ func (c *command) writeNode(depth int, parentKey string, body *hclv2write.Body,
 			{Type: hclv2syntax.TokenIdent, Bytes: []byte(key)},
 		}
 
-		if !isBlock(key, depth, parentKey) {
-			tok = append(tok, tokEqual)
-		} else if len(nv.Keys) > 1 {
+		if c.isBlock(parent, key) {
+			c.debugf("line %d: rendering %s as a block", nv.Pos().Line, key)
 			for _, k := range nv.Keys[1:] {
 				kv := k.Token.Value().(string)
 				tok = append(tok, tokOQuote, &hclv2write.Token{Type: hclv2syntax.TokenQuotedLit, Bytes: []byte(kv)}, tokCQuote)
 			}
+		} else {
+			if len(nv.Keys) > 1 {
+				c.debugf("line %d: nesting keys of %s", nv.Pos().Line, key)
+				nv = nestKeys(nv)
+			}
+			c.debugf("line %d: rendering %s as an attribute", nv.Pos().Line, key)
+			if nv.Keys[0].Token.Type == hclv1token.IDENT && needsQuoting(key) && !c.inBody(parent) {
+				// object attributes can be quoted, but arguments in a
+				// body can't be, so those are left for validation to catch
+				c.debugf("line %d: quoting %s", nv.Pos().Line, key)
+				tok = hclv2write.Tokens{tokOQuote, {Type: hclv2syntax.TokenQuotedLit, Bytes: []byte(key)}, tokCQuote}
+			}
+			if nv.Keys[0].Token.Type == hclv1token.STRING {
+				// a quoted key is a template in hcl v2, so it needs the
+				// same escaping as a string value
+				lit := escapeDirectives(strings.TrimSuffix(strings.TrimPrefix(key, `"`), `"`))
+				tok = hclv2write.Tokens{tokOQuote, {Type: hclv2syntax.TokenQuotedLit, Bytes: []byte(lit)}, tokCQuote}
+			}
+			tok = append(tok, tokEqual)
 		}
 
 		body.AppendUnstructuredTokens(tok)
-		c.writeNode(depth, key, body, nv.Val, cl)
+		c.writeNode(joinPath(parent, key), body, nv.Val, cl)
+
+		if nv.LineComment != nil {
+			// writing the comment group ends the line
+			c.writeNode(parent, body, nv.LineComment, nil)
+		} else {
+			body.AppendNewline()
+		}
+	case *hclv1ast.ObjectList:
+		// items are always written in source order. only --sort-inputs
+		// reorders anything, and only the input variables themselves
+		for i, item := range nv.Items {
+			if i > 0 && needNewline(item, nv.Items[i-1], cl) {
+				body.AppendNewline()
+			}
+			c.writeNode(parent, body, item, cl)
+		}
+	case *hclv1ast.ObjectType:
+		if len(nv.List.Items) == 0 && (cl == nil || len(cl.PeekBefore(nv.Rbrace)) == 0) {
+			// an empty object is written as {}, however it was written
+			body.AppendUnstructuredTokens(hclv2write.Tokens{tokOBrace, tokCBrace})
+			break
+		}
+
+		body.AppendUnstructuredTokens(hclv2write.Tokens{tokOBrace})
+
+		// hcl v1 attaches a comment following the opening brace to the
+		// first item as its line comment, so move it back after the brace
+		list := nv.List
+		if items := list.Items; len(items) > 0 && items[0].LineComment != nil &&
+			items[0].LineComment.Pos().Line == nv.Lbrace.Line && items[0].Pos().Line > nv.Lbrace.Line {
+			first := *items[0]
+			c.writeNode(parent, body, first.LineComment, nil)
+			first.LineComment = nil
+			list = &hclv1ast.ObjectList{Items: append([]*hclv1ast.ObjectItem{&first}, items[1:]...)}
+		} else {
+			body.AppendNewline()
+		}
 
-		if nv.LineComment != nil {
-			c.writeNode(depth, parentKey, body, nv.LineComment, nil)
+		if len(list.Items) > 0 {
+			c.writeNode(parent, body, list, cl)
 		}
 
-		body.AppendNewline()
-	case *hclv1ast.ObjectList:
-		for i, item := range nv.Items {
-			if i > 0 && needNewline(item, nv.Items[i-1], cl) {
-				body.AppendNewline()
-			}
-			c.writeNode(depth+1, parentKey, body, item, cl)
+		// detached comments at the end of the object stay inside of it
+		if cl != nil {
+			c.writeComments(body, cl.PopBefore(nv.Rbrace))
 		}
-	case *hclv1ast.ObjectType:
-		body.AppendUnstructuredTokens(hclv2write.Tokens{tokOBrace, tokNewline})
-		c.writeNode(depth, parentKey, body, nv.List, cl)
 		body.AppendUnstructuredTokens(hclv2write.Tokens{tokCBrace})
 	case *hclv1ast.CommentGroup:
 		for _, c := range nv.List {
@@ -379,7 +2305,106 @@ func (c *command) writeNode(depth int, parentKey string, body *hclv2write.Body,
 				tokNewline,
 			})
 		}
+	default:
+		c.lossf(node.Pos().Line, "dropping unsupported %T node", node)
+	}
+}
+
+// writeSortedInputs writes the input variables sorted by name, either in an
+// inputs block or as top-level attributes. Detached comments inside of a
+// variable's value stay with it, but detached comments between variables
+// can't be placed sensibly once they've been reordered, so they're written
+// above all of the variables.
+func (c *command) writeSortedInputs(body *hclv2write.Body, inputVars []*hclv1ast.ObjectItem, cl *commentList) {
+	sorted := make([]*hclv1ast.ObjectItem, len(inputVars))
+	copy(sorted, inputVars)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.Trim(sorted[i].Keys[0].Token.Text, `"`) < strings.Trim(sorted[j].Keys[0].Token.Text, `"`)
+	})
+
+	var between commentList
+	nested := make(map[*hclv1ast.ObjectItem]*commentList)
+	for _, item := range inputVars {
+		between = append(between, cl.PopBefore(item.Pos())...)
+		inner := cl.PopBefore(endPos(item.Val))
+		nested[item] = &inner
+	}
+
+	if len(between) > 0 {
+		c.writeComments(body, between)
+		body.AppendNewline()
+	}
+
+	if !c.noInputsBlock {
+		body.AppendUnstructuredTokens(hclv2write.Tokens{
+			{Type: hclv2syntax.TokenIdent, Bytes: []byte("inputs")},
+			tokEqual,
+			tokOBrace,
+			tokNewline,
+		})
+	}
+
+	for i, item := range sorted {
+		if i > 0 && needNewline(item, sorted[i-1], nested[item]) {
+			body.AppendNewline()
+		}
+		c.writeNode("inputs", body, item, nested[item])
+	}
+
+	if !c.noInputsBlock {
+		body.AppendUnstructuredTokens(hclv2write.Tokens{tokCBrace, tokNewline})
+	}
+}
+
+// leadingLineComment removes and returns the comment that hcl v1 attaches to
+// the first element of a list or object as its line comment when the comment
+// actually comes before the opening bracket or brace, e.g.:
+//
+//	foo = # about foo
+//	[
+//	  1,
+//	]
+func leadingLineComment(val hclv1ast.Node) *hclv1ast.CommentGroup {
+	var cg *hclv1ast.CommentGroup
+	switch v := val.(type) {
+	case *hclv1ast.ListType:
+		if len(v.List) == 0 {
+			break
+		}
+		if lit, ok := v.List[0].(*hclv1ast.LiteralType); ok && lit.LineComment != nil && lit.LineComment.Pos().Before(v.Lbrack) {
+			cg, lit.LineComment = lit.LineComment, nil
+		}
+	case *hclv1ast.ObjectType:
+		if items := v.List.Items; len(items) > 0 && items[0].LineComment != nil && items[0].LineComment.Pos().Before(v.Lbrace) {
+			cg, items[0].LineComment = items[0].LineComment, nil
+		}
+	}
+	return cg
+}
+
+// endPos returns the position of the end of node: the closing brace or
+// bracket of an object or list, the start of the closing anchor of a
+// heredoc, or the position of anything else.
+func endPos(node hclv1ast.Node) hclv1token.Pos {
+	switch nv := node.(type) {
+	case *hclv1ast.ObjectType:
+		return nv.Rbrace
+	case *hclv1ast.ListType:
+		return nv.Rbrack
+	case *hclv1ast.LiteralType:
+		if nv.Token.Type == hclv1token.HEREDOC {
+			// the token ends with the newline after the anchor
+			text := strings.TrimSuffix(nv.Token.Text, "\n")
+			last := strings.LastIndex(text, "\n") + 1
+			return hclv1token.Pos{
+				Filename: nv.Token.Pos.Filename,
+				Offset:   nv.Token.Pos.Offset + last,
+				Line:     nv.Token.Pos.Line + strings.Count(text, "\n"),
+				Column:   1,
+			}
+		}
 	}
+	return node.Pos()
 }
 
 func (c *command) writeComments(body *hclv2write.Body, comments commentList) {
@@ -388,8 +2413,11 @@ func (c *command) writeComments(body *hclv2write.Body, comments commentList) {
 	}
 
 	for _, cg := range comments {
-		if c := cg.List[0]; c.Start.Line != 1 {
-			// Don't prepend a newline if this comment is the first thing in the file
+		if len(body.BuildTokens(nil)) > 0 && !atBlockStart(body) && !atBlankLine(body) {
+			// Don't prepend a newline if this comment is the first thing in
+			// the output, or the first thing in a block, or if it already
+			// follows a blank line. Blank lines at the start of the
+			// original file are not kept.
 			body.AppendNewline()
 		}
 
@@ -403,17 +2431,82 @@ func (c *command) writeComments(body *hclv2write.Body, comments commentList) {
 			})
 		}
 	}
+}
+
+// lossf reports part of the config at line that will be missing from the
+// upgraded config. With --strict, this is an error; otherwise it's a warning.
+func (c *command) lossf(line int, format string, args ...interface{}) {
+	if c.strict {
+		c.setWriteErr(c.configErrorf(line, format+". the upgrade would lose part of the config", args...))
+		return
+	}
+	c.configWarnf(line, format, args...)
+}
+
+// setWriteErr records an error encountered while writing the upgraded config.
+// Only the first error is kept.
+func (c *command) setWriteErr(err error) {
+	if c.writeErr == nil {
+		c.writeErr = err
+	}
+}
+
+// upgradeNumber returns the hcl v2 representation of a number literal. hcl
+// v1 accepts hexadecimal (0x1F) and octal (017) integers, but hcl v2 only
+// accepts decimal numbers, so these are converted.
+func upgradeNumber(tok hclv1token.Token) (string, error) {
+	if tok.Type == hclv1token.FLOAT {
+		if _, err := strconv.ParseFloat(tok.Text, 64); err != nil {
+			return "", fmt.Errorf("invalid number %s", tok.Text)
+		}
+		return tok.Text, nil
+	}
+
+	n, err := strconv.ParseInt(tok.Text, 0, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid number %s", tok.Text)
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+// atBlockStart returns true if the last tokens written to body open a
+// block, an object, or a list, i.e. the next token will be the first thing
+// in it.
+func atBlockStart(body *hclv2write.Body) bool {
+	tokens := body.BuildTokens(nil)
+	n := len(tokens)
+	return n >= 2 && tokens[n-1].Type == hclv2syntax.TokenNewline &&
+		(tokens[n-2].Type == hclv2syntax.TokenOBrace || tokens[n-2].Type == hclv2syntax.TokenOBrack)
+}
+
+// atBlankLine returns true if the last tokens written to body end with a
+// blank line.
+func atBlankLine(body *hclv2write.Body) bool {
+	tokens := body.BuildTokens(nil)
+	n := len(tokens)
+	return n >= 2 && tokens[n-1].Type == hclv2syntax.TokenNewline && endsLine(tokens[n-2])
+}
 
-	body.AppendNewline()
+// endsLine returns true if tok ends a line: a newline, or a comment that
+// includes its trailing newline, like the migration note.
+func endsLine(tok *hclv2write.Token) bool {
+	return tok.Type == hclv2syntax.TokenNewline ||
+		tok.Type == hclv2syntax.TokenComment && bytes.HasSuffix(tok.Bytes, []byte{'\n'})
 }
 
 func (c *command) writeLiteral(body *hclv2write.Body, val *hclv1ast.LiteralType) {
 	switch val.Token.Type {
 	case hclv1token.NUMBER, hclv1token.FLOAT:
+		num, err := upgradeNumber(val.Token)
+		if err != nil {
+			c.setWriteErr(c.configErrorf(val.Pos().Line, "%v", err))
+			return
+		}
+
 		body.AppendUnstructuredTokens(hclv2write.Tokens{
 			{
 				Type:  hclv2syntax.TokenNumberLit,
-				Bytes: []byte(val.Token.Text),
+				Bytes: []byte(num),
 			},
 		})
 	case hclv1token.BOOL:
@@ -437,8 +2530,44 @@ func (c *command) writeLiteral(body *hclv2write.Body, val *hclv1ast.LiteralType)
 
 		// start from 2; don't include <<
 		delim := val.Token.Text[2 : newlineIdx+1]
+		content := val.Token.Value().(string)
+		closing := strings.TrimSuffix(delim, "\n")
 		if delim[0] == '-' {
 			delim = delim[1:]
+			closing = closing[1:]
+
+			// hcl v2 also supports indented heredocs, so keep the original
+			// indentation if hcl v2 will strip it the same way hcl v1 did.
+			// Otherwise, fall back to a heredoc with the indentation
+			// already stripped.
+			text := strings.TrimSuffix(val.Token.Text, "\n")
+			lastNewline := strings.LastIndexByte(text, '\n')
+			raw := text[newlineIdx+1 : lastNewline+1]
+			if flushHeredoc(raw) == content {
+				delim = "-" + delim
+				content = raw
+				closing = text[lastNewline+1:]
+			} else {
+				c.debugf("line %d: removed indentation from heredoc", val.Pos().Line)
+			}
+		}
+
+		// interpolations in heredocs are copied as they are, so any renamed
+		// functions in them are only upgraded with --rename-in-heredocs
+		var renamed []string
+		if c.renameInHeredocs {
+			content, renamed = renameInHeredoc(content, c.funcs.renames)
+			for _, r := range renamed {
+				c.stats.renames++
+				c.debugf("line %d: renamed function %s in heredoc", val.Pos().Line, r)
+			}
+		}
+		if funcs := heredocCalls(content, c.funcs.renames); len(funcs) > 0 {
+			for _, f := range funcs {
+				c.configWarnf(val.Pos().Line, "heredoc calls %s, which is renamed to %s. rename it by hand, or use --rename-in-heredocs", f, c.funcs.renames[f])
+			}
+		} else if len(renamed) == 0 && len(interpolations(content)) > 0 {
+			c.configWarnf(val.Pos().Line, "heredoc contains interpolations, which were not upgraded. check them by hand")
 		}
 
 		body.AppendUnstructuredTokens(hclv2write.Tokens{
@@ -448,15 +2577,38 @@ func (c *command) writeLiteral(body *hclv2write.Body, val *hclv1ast.LiteralType)
 			},
 			{
 				Type:  hclv2syntax.TokenStringLit,
-				Bytes: []byte(val.Token.Value().(string)),
+				Bytes: []byte(escapeDirectives(content)),
 			},
 			{
+				// unlike the opening marker, the closing marker does not
+				// include the trailing newline
 				Type:  hclv2syntax.TokenCHeredoc,
-				Bytes: []byte(delim),
+				Bytes: []byte(closing),
 			},
 		})
 	case hclv1token.STRING:
-		tmpTok := upgradeExpr(val.Token.Text)
+		text := val.Token.Text
+		if c.expandEnv {
+			var unset []string
+			text, unset = expandEnv(text, os.LookupEnv)
+			if text != val.Token.Text {
+				c.debugf("line %d: expanded environment variables in %s", val.Pos().Line, val.Token.Text)
+			}
+			for _, name := range unset {
+				c.configWarnf(val.Pos().Line, "environment variable %s is not set. it will not be expanded", name)
+			}
+		}
+
+		var tmpTok hclv2syntax.Tokens
+		if c.keepInterpolation {
+			tmpTok, _ = lexExpr(escapeDirectives(text))
+		} else {
+			tmpTok = upgradeExpr(escapeDirectives(text))
+		}
+		if len(tmpTok) > 0 && tmpTok[0].Type != hclv2syntax.TokenOQuote {
+			c.stats.interpolations++
+			c.debugf("line %d: removed interpolation from %s", val.Pos().Line, val.Token.Text)
+		}
 
 		// convert from hclsyntax.Tokens to hclwrite.Tokens
 		var tok hclv2write.Tokens
@@ -467,7 +2619,14 @@ func (c *command) writeLiteral(body *hclv2write.Body, val *hclv1ast.LiteralType)
 			})
 		}
 
-		upgradeFunctionNames(tok)
+		renamed, removed := upgradeFunctionNames(tok, c.funcs)
+		for _, r := range renamed {
+			c.stats.renames++
+			c.debugf("line %d: renamed function %s", val.Pos().Line, r)
+		}
+		for _, f := range removed {
+			c.configWarnf(val.Pos().Line, "function %s was removed in terragrunt v%s, so it must be replaced by hand", f, c.funcs.removed[f])
+		}
 		body.AppendUnstructuredTokens(tok)
 	}
 }
@@ -495,68 +2654,569 @@ func (c *command) loadDetachedComments(f *hclv1ast.File) *commentList {
 				m[val.LeadComment.Pos()] = val.LeadComment
 			}
 
-			if val.LineComment != nil {
-				m[val.LineComment.Pos()] = val.LineComment
-			}
+			if val.LineComment != nil {
+				m[val.LineComment.Pos()] = val.LineComment
+			}
+		}
+
+		return n, true
+	})
+
+	for _, c := range f.Comments {
+		c := c
+		if _, ok := m[c.Pos()]; !ok {
+			ret = append(ret, c)
+		}
+	}
+
+	return &ret
+}
+
+func (c *command) save(path string, contents []byte) error {
+	newPath := c.destPath(path)
+	if c.stdoutJSON {
+		return writeJSONLine(path, contents)
+	} else if c.concat != nil {
+		// the original is left untouched, like a dry run
+		if _, err := fmt.Fprintf(c.concat, "# === %s ===\n%s\n", path, contents); err != nil {
+			return err
+		}
+		c.infof("Added %s to %s", path, newPath)
+		return nil
+	} else if c.previewDir != "" {
+		// the original is left untouched, like a dry run
+		if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+			return err
+		}
+		if err := writeFile(newPath, contents, 0644); err != nil {
+			return err
+		}
+		c.infof("Wrote preview of %s to %s", path, newPath)
+		return nil
+	} else if c.dryRun {
+		fmt.Printf("%s -> %s%s:\n%s\n", path, newPath, c.saveAction(path), contents)
+		return nil
+	} else if newPath == "-" {
+		os.Stdout.Write(contents)
+		return nil
+	}
+
+	// the upgraded config keeps the permissions of the original
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	if newPath == path {
+		// updating in place, or the source is already terragrunt.hcl
+		if err := writeFile(path, contents, perm); err != nil {
+			return err
+		}
+		c.infof("Updated %s", path)
+	} else if c.gitMv && c.batchGit {
+		// staged with the others in stageMoves. only tracked files, like git mv
+		if !c.tracked[absPath(path)] {
+			return fmt.Errorf("not under version control, source=%s, destination=%s", path, newPath)
+		}
+
+		if err := writeFile(newPath, contents, perm); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		c.moves = append(c.moves, gitMove{from: path, to: newPath})
+		c.infof("Updated %s", path)
+	} else if c.gitMv {
+		// if the git mv fails, the source file's contents are restored
+		orig, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(path, contents, perm); err != nil {
+			return err
+		}
+
+		if out, err := exec.Command("git", "mv", path, newPath).CombinedOutput(); err != nil {
+			err = fmt.Errorf("git mv %s %s failed: %v: %s", path, newPath, err, bytes.TrimSpace(out))
+			if rerr := writeFile(path, orig, perm); rerr != nil {
+				return fmt.Errorf("%v. restoring %s also failed, so it has the upgraded contents: %v", err, path, rerr)
+			}
+			return err
+		}
+		c.infof("Updated %s", path)
+	} else {
+		// the original is only removed once the new config is written
+		if err := writeFile(newPath, contents, perm); err != nil {
+			return err
+		}
+		c.infof("Updated %s", path)
+
+		if !c.keepOld {
+			return os.Remove(path)
+		}
+	}
+
+	return nil
+}
+
+// absPath returns the absolute form of path, or path itself if that fails.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// changedFiles returns the paths of the files that differ from the git ref
+// since, including uncommitted changes, with symlinks resolved. Files that
+// aren't tracked by git are not included.
+func changedFiles(since string) (map[string]bool, error) {
+	if strings.HasPrefix(since, "-") {
+		// it would be treated as an option by git
+		return nil, fmt.Errorf("invalid --since ref %q", since)
+	}
+
+	root, err := gitRoot(".")
+	if err != nil {
+		return nil, fmt.Errorf("--since can only be used inside of a git repository: %v", err)
+	}
+
+	// the paths are relative to the root of the repository
+	cmd := exec.Command("git", "diff", "--name-only", "-z", since, "--")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing files changed since %s: %v: %s", since, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	changed := make(map[string]bool)
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			changed[resolvedPath(filepath.Join(root, filepath.FromSlash(f)))] = true
+		}
+	}
+	return changed, nil
+}
+
+// resolvedPath returns the absolute path of path with any symlinks
+// resolved, if it exists, so that it can be compared to the paths git
+// reports.
+func resolvedPath(path string) string {
+	abs := absPath(path)
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	return abs
+}
+
+// trackedFiles returns the absolute paths of the files in paths that are
+// tracked by git, using a single git command.
+func trackedFiles(paths []string) (map[string]bool, error) {
+	cmd := exec.Command("git", append([]string{"ls-files", "-z", "--"}, paths...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing files tracked by git: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	tracked := make(map[string]bool)
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			tracked[absPath(f)] = true
+		}
+	}
+	return tracked, nil
+}
+
+// stageMoves stages the renames in moves, as git mv would have. All of them
+// are staged with one "git rm --cached" and one "git add". If either fails,
+// each rename is staged separately so a failure can be attributed to the
+// file that caused it. The returned map holds the error for each source
+// path whose rename could not be staged.
+func stageMoves(moves []gitMove) map[string]error {
+	stage := func(moves []gitMove) error {
+		var from, to []string
+		for _, m := range moves {
+			from = append(from, m.from)
+			to = append(to, m.to)
+		}
+
+		for _, args := range [][]string{
+			append([]string{"rm", "--cached", "--quiet", "--"}, from...),
+			append([]string{"add", "--"}, to...),
+		} {
+			var stderr bytes.Buffer
+			cmd := exec.Command("git", args...)
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("git %s: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+			}
+		}
+		return nil
+	}
+
+	if len(moves) == 0 || stage(moves) == nil {
+		return nil
+	}
+
+	errs := make(map[string]error)
+	for _, m := range moves {
+		if err := stage([]gitMove{m}); err != nil {
+			errs[m.from] = fmt.Errorf("%s was renamed to %s, but the rename could not be staged: %v", m.from, m.to, err)
+		}
+	}
+	return errs
+}
+
+// writeFile writes contents to path atomically, by writing them to a
+// temporary file in the same directory and renaming it into place. A failure
+// part way through, or a second interrupt that stops the process right away,
+// never leaves a truncated file behind, so callers can remove or restore the
+// original once it returns.
+func writeFile(path string, contents []byte, perm os.FileMode) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := f.Write(contents); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// destPath returns the path that the upgraded config for path will be
+// written to, or "-" for stdout.
+func (c *command) destPath(path string) string {
+	if path == "-" || c.stdout || c.stdoutJSON {
+		return "-"
+	}
+
+	dest := filepath.Join(filepath.Dir(path), "terragrunt.hcl")
+	if c.inPlace {
+		// a compressed config is upgraded to an uncompressed file next
+		// to it
+		dest = strings.TrimSuffix(path, ".gz")
+	}
+	if c.concatPath != "" {
+		return c.concatPath
+	} else if c.previewDir != "" {
+		return previewPath(c.previewDir, dest)
+	}
+	return dest
+}
+
+// previewPath returns where --preview-dir writes the config that would be
+// written to path: the same path relative to the current directory, under
+// dir. A path outside of the current directory is placed under dir by its
+// absolute path.
+func previewPath(dir, path string) string {
+	abs := absPath(path)
+	if wd, err := os.Getwd(); err == nil {
+		rel, err := filepath.Rel(wd, abs)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.Join(dir, rel)
+		}
+	}
+	return filepath.Join(dir, strings.TrimPrefix(abs, filepath.VolumeName(abs)))
+}
+
+// saveAction describes what save will do with the source file at path
+// once the upgraded config has been written.
+func (c *command) saveAction(path string) string {
+	switch {
+	case c.destPath(path) == "-" || c.destPath(path) == path || c.previewDir != "" || c.concatPath != "":
+		return ""
+	case c.gitMv:
+		return " (git mv)"
+	case c.keepOld:
+		return " (keeping original)"
+	default:
+		return " (removing original)"
+	}
+}
+
+// nestKeys expands an object item with multiple keys into the equivalent
+// nested objects. hcl v1 treats foo "bar" { baz = 1 } as shorthand for
+// foo = { bar = { baz = 1 } }, but hcl v2 only allows labels on blocks.
+func nestKeys(item *hclv1ast.ObjectItem) *hclv1ast.ObjectItem {
+	val := item.Val
+	for i := len(item.Keys) - 1; i > 0; i-- {
+		val = &hclv1ast.ObjectType{
+			List: &hclv1ast.ObjectList{
+				Items: []*hclv1ast.ObjectItem{
+					{Keys: []*hclv1ast.ObjectKey{item.Keys[i]}, Val: val},
+				},
+			},
+		}
+	}
+
+	return &hclv1ast.ObjectItem{
+		Keys:        item.Keys[:1],
+		Assign:      item.Assign,
+		Val:         val,
+		LeadComment: item.LeadComment,
+		LineComment: item.LineComment,
+	}
+}
+
+// dumpAST writes a description of a parsed config to w: the settings found
+// in the terragrunt attribute, the input variables, and the comments that
+// are not attached to any node.
+func dumpAST(w io.Writer, tgSettings, inputVars []*hclv1ast.ObjectItem, cl *commentList) {
+	fmt.Fprintln(w, "terragrunt settings:")
+	for _, item := range tgSettings {
+		dumpNode(w, item, 1)
+	}
+
+	fmt.Fprintln(w, "inputs:")
+	for _, item := range inputVars {
+		dumpNode(w, item, 1)
+	}
+
+	fmt.Fprintln(w, "detached comments:")
+	for _, cg := range *cl {
+		dumpNode(w, cg, 1)
+	}
+}
+
+// dumpNode writes a description of node and its children to w, one node
+// per line, indented by depth.
+func dumpNode(w io.Writer, node hclv1ast.Node, depth int) {
+	prefix := strings.Repeat("  ", depth)
+	pos := node.Pos()
+
+	switch nv := node.(type) {
+	case *hclv1ast.ObjectList:
+		for _, item := range nv.Items {
+			dumpNode(w, item, depth)
+		}
+	case *hclv1ast.ObjectItem:
+		var keys []string
+		for _, k := range nv.Keys {
+			keys = append(keys, k.Token.Text)
+		}
+		fmt.Fprintf(w, "%s%d:%d ObjectItem %s\n", prefix, pos.Line, pos.Column, strings.Join(keys, " "))
+		if nv.LeadComment != nil {
+			fmt.Fprintf(w, "%s  lead comment:\n", prefix)
+			dumpNode(w, nv.LeadComment, depth+2)
+		}
+		if nv.LineComment != nil {
+			fmt.Fprintf(w, "%s  line comment:\n", prefix)
+			dumpNode(w, nv.LineComment, depth+2)
+		}
+		dumpNode(w, nv.Val, depth+1)
+	case *hclv1ast.ObjectType:
+		fmt.Fprintf(w, "%s%d:%d ObjectType\n", prefix, pos.Line, pos.Column)
+		dumpNode(w, nv.List, depth+1)
+	case *hclv1ast.ListType:
+		fmt.Fprintf(w, "%s%d:%d ListType\n", prefix, pos.Line, pos.Column)
+		for _, n := range nv.List {
+			dumpNode(w, n, depth+1)
+		}
+	case *hclv1ast.LiteralType:
+		fmt.Fprintf(w, "%s%d:%d LiteralType %s %s\n", prefix, pos.Line, pos.Column, nv.Token.Type, nv.Token.Text)
+		if nv.LeadComment != nil {
+			fmt.Fprintf(w, "%s  lead comment:\n", prefix)
+			dumpNode(w, nv.LeadComment, depth+2)
+		}
+		if nv.LineComment != nil {
+			fmt.Fprintf(w, "%s  line comment:\n", prefix)
+			dumpNode(w, nv.LineComment, depth+2)
+		}
+	case *hclv1ast.CommentGroup:
+		for _, c := range nv.List {
+			fmt.Fprintf(w, "%s%d:%d %s\n", prefix, c.Start.Line, c.Start.Column, c.Text)
+		}
+	}
+}
+
+// terragruntSettings lists the names terragrunt v0.19 reserves at the top
+// level of a config.
+var terragruntSettings = map[string]bool{
+	"terraform":                    true,
+	"remote_state":                 true,
+	"include":                      true,
+	"dependencies":                 true,
+	"dependency":                   true,
+	"locals":                       true,
+	"inputs":                       true,
+	"prevent_destroy":              true,
+	"skip":                         true,
+	"iam_role":                     true,
+	"terraform_binary":             true,
+	"terraform_version_constraint": true,
+	"download_dir":                 true,
+}
+
+// removedSettings lists the terragrunt settings that terragrunt v0.18 still
+// accepted, but ignored, and that terragrunt v0.19 removed from its config,
+// with the reason each was ignored. Upgrading them as they are would produce
+// a config terragrunt rejects.
+var removedSettings = map[string]string{
+	"lock": "terragrunt v0.18 ignored it with a warning, since locking is configured in the terraform backend",
+}
+
+// dropRemovedSettings returns tgSettings without any settings listed in
+// removedSettings, along with any comments inside of them. Each one is
+// reported, and with --removed-settings=comment, its source is added to cl
+// as a comment in its place.
+func (c *command) dropRemovedSettings(tgSettings []*hclv1ast.ObjectItem, input []byte, cl *commentList) []*hclv1ast.ObjectItem {
+	var kept []*hclv1ast.ObjectItem
+	for _, item := range tgSettings {
+		key := item.Keys[0].Token.Text
+		reason, ok := removedSettings[key]
+		if !ok {
+			kept = append(kept, item)
+			continue
 		}
 
-		return n, true
-	})
-
-	for _, c := range f.Comments {
-		c := c
-		if _, ok := m[c.Pos()]; !ok {
-			ret = append(ret, c)
+		cl.PopBetween(item.Pos(), endPos(item.Val))
+		if c.removedSettings == "comment" {
+			c.configWarnf(item.Pos().Line, "commenting out %s, which terragrunt v0.19 does not support. %s", key, reason)
+			cl.Insert(commentOut(item, input, fmt.Sprintf("TODO: %s is not supported by terragrunt v0.19. %s", key, reason)))
+		} else {
+			c.lossf(item.Pos().Line, "dropping %s, which terragrunt v0.19 does not support. %s", key, reason)
 		}
 	}
-
-	return &ret
+	return kept
 }
 
-func (c *command) save(path string, contents []byte) error {
-	// check the new config
-	p := hclv2parse.NewParser()
-	_, diags := p.ParseHCL(contents, path)
-	if diags.HasErrors() {
-		var d tfdiags.Diagnostics
-		d = d.Append(diags)
-		return d.Err()
+// commentOut returns a comment group for item, starting with its lead
+// comment and note, followed by its source, copied from input, commented out
+// line by line.
+func commentOut(item *hclv1ast.ObjectItem, input []byte, note string) *hclv1ast.CommentGroup {
+	cg := &hclv1ast.CommentGroup{}
+	add := func(text string) {
+		cg.List = append(cg.List, &hclv1ast.Comment{Start: item.Pos(), Text: text})
 	}
 
-	if c.dryRun {
-		fmt.Printf("%s:\n%s\n", path, contents)
-		return nil
-	} else if path == "-" {
-		os.Stdout.Write(contents)
-		return nil
+	if item.LeadComment != nil {
+		for _, c := range item.LeadComment.List {
+			add(c.Text)
+		}
 	}
-
-	base := filepath.Dir(path)
-	newPath := filepath.Join(base, "terragrunt.hcl")
-	if c.gitMv {
-		// update the source file and git mv it
-		err := ioutil.WriteFile(path, contents, 0644)
-		if err != nil {
-			return err
+	add("# " + note)
+
+	lines := strings.Split(string(input), "\n")
+	first := lines[item.Pos().Line-1]
+	indent := first[:len(first)-len(strings.TrimLeft(first, " \t"))]
+	for _, line := range lines[item.Pos().Line-1 : endPos(item.Val).Line] {
+		if line = strings.TrimRight(strings.TrimPrefix(line, indent), " \t"); line == "" {
+			add("#")
+		} else {
+			add("# " + line)
 		}
-		fmt.Printf("Updated %s\n", path)
+	}
+	return cg
+}
 
-		cmd := exec.Command("git", "mv", path, newPath)
-		if err := cmd.Run(); err != nil {
-			return err
+// collidingInputs returns the input variables named after a terragrunt
+// setting. These are fine inside of an inputs block, but not as top-level
+// attributes.
+func collidingInputs(inputVars []*hclv1ast.ObjectItem) []*hclv1ast.ObjectItem {
+	var ret []*hclv1ast.ObjectItem
+	for _, item := range inputVars {
+		if terragruntSettings[strings.Trim(item.Keys[0].Token.Text, `"`)] {
+			ret = append(ret, item)
 		}
-	} else {
-		err := ioutil.WriteFile(newPath, contents, 0644)
-		if err != nil {
-			return err
+	}
+	return ret
+}
+
+// nestedTerragruntKeys returns the positions of any terragrunt keys that
+// appear somewhere other than the top level of the config. Only a top-level
+// terragrunt attribute is upgraded; anything else is treated as an input.
+func nestedTerragruntKeys(root *hclv1ast.ObjectList) []hclv1token.Pos {
+	topLevel := make(map[*hclv1ast.ObjectKey]bool)
+	for _, item := range root.Items {
+		topLevel[item.Keys[0]] = true
+	}
+
+	var found []hclv1token.Pos
+	hclv1ast.Walk(root, func(n hclv1ast.Node) (hclv1ast.Node, bool) {
+		if k, ok := n.(*hclv1ast.ObjectKey); ok && !topLevel[k] {
+			if strings.Trim(k.Token.Text, `"`) == "terragrunt" {
+				found = append(found, k.Pos())
+			}
 		}
-		fmt.Printf("Updated %s\n", path)
+		return n, true
+	})
 
-		if !c.keepOld {
-			return os.Remove(path)
+	return found
+}
+
+// upgradedBlocks and upgradedAttrs are the top-level blocks and attributes
+// that only appear in terragrunt >= v0.19 configs.
+var (
+	upgradedBlocks = map[string]bool{"include": true, "terraform": true, "remote_state": true, "dependencies": true}
+	upgradedAttrs  = map[string]bool{"inputs": true, "remote_state": true}
+)
+
+// isUpgraded returns true if input appears to already be a terragrunt >= v0.19
+// config: it is valid hcl v2, does not contain a terragrunt attribute, and
+// has at least one top-level block or attribute that only a terragrunt
+// >= v0.19 config would have. A plain .tfvars file is valid hcl v2 as well,
+// so that alone isn't enough.
+func isUpgraded(input []byte) bool {
+	f, diags := hclv2syntax.ParseConfig(input, "", hclv2.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return false
+	}
+
+	body := f.Body.(*hclv2syntax.Body)
+	if _, ok := body.Attributes["terragrunt"]; ok {
+		return false
+	}
+	for name := range body.Attributes {
+		if upgradedAttrs[name] {
+			return true
+		}
+	}
+	for _, block := range body.Blocks {
+		if upgradedBlocks[block.Type] {
+			return true
 		}
 	}
+	return false
+}
+
+// stringList is a flag.Value for flags that may be specified multiple times.
+// Values specified on the command line replace the default value.
+type stringList struct {
+	values []string
+	set    bool
+}
 
+func (s *stringList) String() string {
+	return strings.Join(s.values, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	if !s.set {
+		s.values = nil
+		s.set = true
+	}
+	s.values = append(s.values, v)
 	return nil
 }
 
@@ -602,6 +3262,37 @@ func (cl *commentList) PopBefore(pos hclv1token.Pos) commentList {
 	return ret
 }
 
+// Insert adds cg to the list, which is kept in order of position.
+func (cl *commentList) Insert(cg *hclv1ast.CommentGroup) {
+	i := sort.Search(len(*cl), func(i int) bool {
+		return (*cl)[i].Pos().After(cg.Pos())
+	})
+	*cl = append(*cl, nil)
+	copy((*cl)[i+1:], (*cl)[i:])
+	(*cl)[i] = cg
+}
+
+// PopBetween removes and returns the comments that fall after start and no
+// later than end.
+func (cl *commentList) PopBetween(start, end hclv1token.Pos) commentList {
+	var ret, rest commentList
+	for _, cg := range *cl {
+		if cg.Pos().After(start) && !cg.Pos().After(end) {
+			ret = append(ret, cg)
+		} else {
+			rest = append(rest, cg)
+		}
+	}
+	*cl = rest
+	return ret
+}
+
+// migrationNote returns the comment written at the top of upgraded configs
+// when --add-migration-note is specified.
+func migrationNote(t time.Time) string {
+	return fmt.Sprintf("# Upgraded by %s %s on %s\n", name, version.Version, t.Format("2006-01-02"))
+}
+
 func tokComment(text string) *hclv2write.Token {
 	return &hclv2write.Token{
 		Type:  hclv2syntax.TokenComment,
@@ -609,23 +3300,247 @@ func tokComment(text string) *hclv2write.Token {
 	}
 }
 
-var topLevelBlocks = []string{"terraform", "remote_state", "include", "dependencies"}
+// blockRules lists the settings that are rendered as blocks, keyed by the
+// path to the block they are nested in. Top-level settings have an empty
+// path, and paths to nested blocks are joined with dots, e.g.
+// "terraform.extra_arguments". Anything else is an attribute, even if it was
+// written as a block, like remote_state's config and generate maps.
+var blockRules = map[string][]string{
+	"":          {"terraform", "remote_state", "include", "dependencies", "dependency"},
+	"terraform": {"extra_arguments", "before_hook", "after_hook"},
+}
 
 // isBlock returns a boolean indicating whether the node identified by
-// key at the given depth under the specified parent should be a block.
-// If this returns false, the node should be an attribute.
-func isBlock(key string, depth int, parent string) bool {
-	if depth == 0 {
-		for _, k := range topLevelBlocks {
-			if key == k {
-				return true
+// key under the specified parent path should be a block. If this returns
+// false, the node should be an attribute.
+func (c *command) isBlock(parent, key string) bool {
+	if parent == "" && key == "remote_state" && c.rules.remoteStateAttr {
+		return false
+	}
+
+	for _, k := range blockRules[parent] {
+		if key == k {
+			return true
+		}
+	}
+
+	return false
+}
+
+// terragruntBlocks describes the blocks that the upgrade can produce, keyed
+// by path like blockRules: the number of labels each block must have, and
+// the attributes it requires.
+var terragruntBlocks = map[string]struct {
+	labels   int
+	required []string
+}{
+	"remote_state":              {required: []string{"backend"}},
+	"include":                   {required: []string{"path"}},
+	"dependencies":              {required: []string{"paths"}},
+	"dependency":                {labels: 1, required: []string{"config_path"}},
+	"terraform":                 {},
+	"terraform.extra_arguments": {labels: 1, required: []string{"commands"}},
+	"terraform.before_hook":     {labels: 1, required: []string{"commands", "execute"}},
+	"terraform.after_hook":      {labels: 1, required: []string{"commands", "execute"}},
+}
+
+// checkTerragrunt checks that an upgraded config has the structure that
+// terragrunt expects, and returns a description of each problem found.
+// Unlike the hcl v2 syntax check in upgrade, this catches configs that parse
+// but would be rejected by terragrunt.
+func checkTerragrunt(src []byte) []string {
+	f, diags := hclv2syntax.ParseConfig(src, "terragrunt.hcl", hclv2.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return []string{diags.Error()}
+	}
+
+	var problems []string
+	var check func(parent string, body *hclv2syntax.Body)
+	check = func(parent string, body *hclv2syntax.Body) {
+		for _, block := range body.Blocks {
+			path := joinPath(parent, block.Type)
+			rules, ok := terragruntBlocks[path]
+			if !ok {
+				continue
+			}
+
+			line := block.TypeRange.Start.Line
+			if len(block.Labels) != rules.labels {
+				problems = append(problems, fmt.Sprintf("line %d: %s block must have %d label(s), but has %d", line, block.Type, rules.labels, len(block.Labels)))
+			}
+			for _, attr := range rules.required {
+				if _, ok := block.Body.Attributes[attr]; !ok {
+					problems = append(problems, fmt.Sprintf("line %d: %s block is missing %s", line, block.Type, attr))
+				}
+			}
+
+			check(path, block.Body)
+		}
+	}
+	check("", f.Body.(*hclv2syntax.Body))
+
+	// remote_state may also be an attribute, which must be an object with
+	// the same required attributes as the block
+	if attr, ok := f.Body.(*hclv2syntax.Body).Attributes["remote_state"]; ok {
+		line := attr.NameRange.Start.Line
+		if obj, ok := attr.Expr.(*hclv2syntax.ObjectConsExpr); !ok {
+			problems = append(problems, fmt.Sprintf("line %d: remote_state must be an object", line))
+		} else {
+			keys := make(map[string]bool)
+			for _, item := range obj.Items {
+				keys[objectKey(item.KeyExpr)] = true
+			}
+			for _, key := range terragruntBlocks["remote_state"].required {
+				if !keys[key] {
+					problems = append(problems, fmt.Sprintf("line %d: remote_state is missing %s", line, key))
+				}
 			}
 		}
-	} else if depth == 1 && parent == "terraform" && key == "extra_arguments" {
+	}
+
+	return problems
+}
+
+// objectKey returns the name of a key in an object constructor, whether it's
+// an identifier or a quoted string. It returns "" for any other expression.
+func objectKey(expr hclv2syntax.Expression) string {
+	if k := hclv2.ExprAsKeyword(expr); k != "" {
+		return k
+	}
+
+	if key, ok := expr.(*hclv2syntax.ObjectConsKeyExpr); ok {
+		if tmpl, ok := key.Wrapped.(*hclv2syntax.TemplateExpr); ok && tmpl.IsStringLiteral() {
+			v, _ := tmpl.Value(nil)
+			return v.AsString()
+		}
+	}
+	return ""
+}
+
+// needsQuoting returns true if key can't be written as a bare attribute name
+// in an hcl v2 object. hcl v1 allows periods in identifiers, but in hcl v2 a
+// key like foo.bar is a reference, and a key named for starts a for
+// expression.
+func needsQuoting(key string) bool {
+	return !hclv2syntax.ValidIdentifier(key) || key == "for"
+}
+
+// inBody returns true if the items nested under parent are written as the
+// arguments of a body (the top level of the config, or a block) rather than
+// the attributes of an object.
+func (c *command) inBody(parent string) bool {
+	if parent == "" || (parent == "inputs" && c.noInputsBlock) {
 		return true
 	}
 
-	return false
+	i := strings.LastIndex(parent, ".")
+	if i < 0 {
+		return c.isBlock("", parent)
+	}
+	return c.isBlock(parent[:i], parent[i+1:])
+}
+
+// joinPath returns the path to key nested under parent.
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+const defaultTargetVersion = "0.19.0"
+
+// defaultFromVersion is the version of terragrunt configs are assumed to be
+// written for if --from-version isn't specified.
+const defaultFromVersion = "0.18.0"
+
+// renderRules control how terragrunt settings are rendered for a particular
+// version of terragrunt. The config attribute of remote_state is always
+// rendered as an attribute, as no version of terragrunt >= v0.19 accepts it
+// as a block.
+type renderRules struct {
+	// remoteStateAttr renders remote_state as an attribute
+	// (remote_state = {...}) rather than a block (remote_state {...}).
+	remoteStateAttr bool
+}
+
+// targetVersions maps the earliest version of terragrunt that accepts a set
+// of rules to those rules. Entries are sorted by version.
+var targetVersions = []struct {
+	version string
+	rules   renderRules
+}{
+	{version: "0.19.0", rules: renderRules{}},
+	// terragrunt v0.23.0 began accepting remote_state as an attribute
+	{version: "0.23.0", rules: renderRules{remoteStateAttr: true}},
+}
+
+// renderRules returns the rules for the newest version in targetVersions
+// that is no newer than the target version.
+func (c *command) renderRules() (renderRules, error) {
+	target := c.targetVersion
+	if target == "" {
+		target = defaultTargetVersion
+	}
+
+	tv, err := parseVersion(target)
+	if err != nil {
+		return renderRules{}, fmt.Errorf("invalid target version: %v", err)
+	}
+
+	if first, _ := parseVersion(targetVersions[0].version); compareVersions(tv, first) < 0 {
+		return renderRules{}, fmt.Errorf("invalid target version %s: must be >= %s", target, first)
+	}
+
+	var rules renderRules
+	for _, v := range targetVersions {
+		vv, _ := parseVersion(v.version)
+		if compareVersions(tv, vv) < 0 {
+			break
+		}
+		rules = v.rules
+	}
+
+	return rules, nil
+}
+
+type semver [3]int
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// parseVersion parses a version of the form [v]major.minor[.patch].
+func parseVersion(s string) (semver, error) {
+	var v semver
+
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("malformed version %q", s)
+	}
+
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("malformed version %q", s)
+		}
+		v[i] = n
+	}
+
+	return v, nil
+}
+
+// compareVersions returns -1, 0, or 1 if a is older than, the same as,
+// or newer than b.
+func compareVersions(a, b semver) int {
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		} else if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
 }
 
 // needNewline returns true if an extra newline is needed between
@@ -634,54 +3549,225 @@ func isBlock(key string, depth int, parent string) bool {
 //   - The current or previous node is an object
 //   - The current or previous node is a multiline list
 // But not if:
-//   - The previous element had a line comment
 //   - There is a detached comment after the previous node
 func needNewline(curr, prev *hclv1ast.ObjectItem, cl *commentList) bool {
-	if prev.LineComment != nil {
-		// The previous line comment includes a newline
-		return false
-	} else if c := cl.PeekBefore(curr.Pos()); len(c) > 0 {
+	if c := cl.PeekBefore(curr.Pos()); len(c) > 0 {
 		// The previous detached comment includes a newline
 		return false
-	} else if curr.LeadComment != nil {
+	} else if curr.LeadComment != nil || len(cl.PeekBefore(curr.Val.Pos())) > 0 {
+		// comments above the key, or between the key and the value, which
+		// are moved above the key
 		return true
 	}
 
-	switch v := curr.Val.(type) {
-	case *hclv1ast.LiteralType:
-		if v.LeadComment != nil {
-			return true
-		}
-	case *hclv1ast.ListType:
-		if v.Lbrack.Line != v.Rbrack.Line {
-			return true
-		}
-	case *hclv1ast.ObjectType:
+	if v, ok := curr.Val.(*hclv1ast.LiteralType); ok && v.LeadComment != nil {
+		return true
+	}
+
+	// hclwrite treats a heredoc as part of the line it starts on, so the
+	// attributes after it would be aligned with the ones before it. a blank
+	// line ends the group instead
+	if v, ok := prev.Val.(*hclv1ast.LiteralType); ok && v.Token.Type == hclv1token.HEREDOC {
 		return true
 	}
 
-	switch v := prev.Val.(type) {
+	// the comments inside of the previous node have already been written
+	return isMultiline(curr.Val, cl) || isMultiline(prev.Val, nil)
+}
+
+// isMultiline returns true if node is an object or a multiline list. Empty
+// objects and lists are written on a single line unless they contain
+// comments, so those aren't included. If cl is nil, the node's comments
+// have already been written, so an empty object or list is assumed to have
+// contained comments if it spanned more than one line.
+func isMultiline(node hclv1ast.Node, cl *commentList) bool {
+	switch v := node.(type) {
 	case *hclv1ast.ListType:
-		if v.Lbrack.Line != v.Rbrack.Line {
-			return true
+		if len(v.List) > 0 || cl == nil {
+			return v.Lbrack.Line != v.Rbrack.Line
 		}
+		return len(cl.PeekBefore(v.Rbrack)) > 0
 	case *hclv1ast.ObjectType:
-		return true
+		if len(v.List.Items) > 0 {
+			return true
+		} else if cl == nil {
+			return v.Lbrace.Line != v.Rbrace.Line
+		}
+		return len(cl.PeekBefore(v.Rbrace)) > 0
 	}
-
 	return false
 }
 
-func upgradeExpr(expr string) hclv2syntax.Tokens {
+// flushHeredoc returns the contents of an indented (<<-) heredoc the way
+// hcl v2 interprets them: the smallest indentation of any line that is not
+// blank is removed from each of those lines.
+func flushHeredoc(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+
+	min := -1
+	for _, l := range lines {
+		trimmed := strings.TrimLeftFunc(l, unicode.IsSpace)
+		if trimmed == "" {
+			continue
+		}
+		if n := len(l) - len(trimmed); min < 0 || n < min {
+			min = n
+		}
+	}
+
+	var b strings.Builder
+	for _, l := range lines {
+		if strings.TrimLeftFunc(l, unicode.IsSpace) != "" {
+			l = l[min:]
+		}
+		b.WriteString(l)
+	}
+	return b.String()
+}
+
+// shellVar matches a shell-style environment variable reference, $VAR or
+// ${VAR}, or an escaped dollar sign ($$). ${VAR} is only a shell variable if
+// it contains nothing but a variable name; anything else in ${...} is an hcl
+// interpolation, e.g. ${var.foo} or ${get_env("FOO", "")}.
+var shellVar = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// stringEscaper escapes text for use in a quoted hcl string.
+var stringEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"${", "$${",
+)
+
+// expandEnv expands shell-style environment variables in the text of a
+// quoted string, using lookup to find their values. Variables that are not
+// set are left as they are, and their names are returned. $$ escapes a
+// dollar sign in both hcl and the shell, so it is never expanded.
+func expandEnv(text string, lookup func(string) (string, bool)) (string, []string) {
+	var unset []string
+	expanded := shellVar.ReplaceAllStringFunc(text, func(m string) string {
+		if m == "$$" {
+			return m
+		}
+
+		name := strings.Trim(m, "${}")
+		v, ok := lookup(name)
+		if !ok {
+			unset = append(unset, name)
+			return m
+		}
+		return stringEscaper.Replace(v)
+	})
+	return expanded, unset
+}
+
+// escapeDirectives escapes the start of any template directives (%{) in a
+// string or heredoc. hcl v1 has no template directives, so these are always
+// meant literally, but hcl v2 would try to evaluate them. Other escape
+// sequences mean the same thing in both versions and are left alone.
+func escapeDirectives(s string) string {
+	return strings.Replace(s, "%{", "%%{", -1)
+}
+
+// interpolations returns the start and end offsets of each interpolation
+// (${...}) in s, including the ${ and }. Escaped interpolations ($${) are
+// skipped.
+func interpolations(s string) [][2]int {
+	var spans [][2]int
+	for i := 0; i < len(s)-1; i++ {
+		if strings.HasPrefix(s[i:], "$${") {
+			i += 2
+			continue
+		} else if !strings.HasPrefix(s[i:], "${") {
+			continue
+		}
+
+		depth := 0
+		for j := i + 2; j < len(s); j++ {
+			if s[j] == '{' {
+				depth++
+			} else if s[j] == '}' && depth > 0 {
+				depth--
+			} else if s[j] == '}' {
+				spans = append(spans, [2]int{i, j + 1})
+				i = j
+				break
+			}
+		}
+	}
+	return spans
+}
+
+// renamedCall matches a call to a function with no arguments, since none
+// of the renamed functions take any.
+func renamedCall(name string) *regexp.Regexp {
+	return regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\(\s*\)`)
+}
+
+// heredocCalls returns the functions in renames called in interpolations in
+// the heredoc content s.
+func heredocCalls(s string, renames map[string]string) []string {
+	var calls []string
+	seen := make(map[string]bool)
+	for _, span := range interpolations(s) {
+		for old := range renames {
+			if !seen[old] && renamedCall(old).MatchString(s[span[0]:span[1]]) {
+				seen[old] = true
+				calls = append(calls, old)
+			}
+		}
+	}
+	sort.Strings(calls)
+	return calls
+}
+
+// renameInHeredoc renames calls to the functions in renames in
+// interpolations in the heredoc content s, and returns the new content along
+// with a description of each rename.
+func renameInHeredoc(s string, renames map[string]string) (string, []string) {
+	var (
+		b       strings.Builder
+		renamed []string
+		last    int
+	)
+	for _, span := range interpolations(s) {
+		interp := s[span[0]:span[1]]
+		for old, name := range renames {
+			interp = renamedCall(old).ReplaceAllStringFunc(interp, func(string) string {
+				renamed = append(renamed, fmt.Sprintf("%s -> %s", old, name))
+				return name + "()"
+			})
+		}
+		b.WriteString(s[last:span[0]])
+		b.WriteString(interp)
+		last = span[1]
+	}
+	b.WriteString(s[last:])
+	sort.Strings(renamed)
+	return b.String(), renamed
+}
+
+// lexExpr splits expr into hcl v2 tokens, without the trailing EOF token.
+func lexExpr(expr string) (hclv2syntax.Tokens, bool) {
 	tok, diag := hclv2syntax.LexExpression([]byte(expr), "", hclv2.Pos{})
 	if diag.HasErrors() {
-		// TODO: should probably do something about this.
-		return tok
+		return tok, false
 	}
 
 	if tok[len(tok)-1].Type == hclv2syntax.TokenEOF {
 		tok = tok[:len(tok)-1]
 	}
+	return tok, true
+}
+
+func upgradeExpr(expr string) hclv2syntax.Tokens {
+	tok, ok := lexExpr(expr)
+	if !ok {
+		// TODO: should probably do something about this.
+		return tok
+	}
 
 	if len(tok) < 5 {
 		// Not enough tokens for an interpolation (open quote, start template (${), inner token(s), close template (}), close quote)
@@ -699,6 +3785,9 @@ func upgradeExpr(expr string) hclv2syntax.Tokens {
 		return tok
 	}
 
+	// Track whether each token is inside of a string literal nested in the
+	// interpolation, e.g. the "a" in "${var.b ? "a" : "c"}". Template tokens
+	// inside of a nested string belong to that string, not the outer one.
 	quotes := 0
 	for _, t := range inner {
 		if t.Type == hclv2syntax.TokenOQuote {
@@ -723,20 +3812,99 @@ func upgradeExpr(expr string) hclv2syntax.Tokens {
 	return inner
 }
 
-var renameFuncs = map[string]string{
-	"get_tfvars_dir":        "get_terragrunt_dir",
-	"get_parent_tfvars_dir": "get_parent_terragrunt_dir",
+// funcChanges lists the changes made to interpolation functions by each
+// version of terragrunt, sorted by version. to is empty if the function was
+// removed rather than renamed.
+var funcChanges = []struct {
+	version  string
+	from, to string
+}{
+	{version: "0.19.0", from: "get_tfvars_dir", to: "get_terragrunt_dir"},
+	{version: "0.19.0", from: "get_parent_tfvars_dir", to: "get_parent_terragrunt_dir"},
+}
+
+// funcUpgrades describes the changes to interpolation functions between the
+// version of terragrunt a config is upgraded from and v0.19.
+type funcUpgrades struct {
+	// renames maps the old name of each renamed function to its new name
+	renames map[string]string
+	// removed maps each removed function to the version that removed it
+	removed map[string]string
+}
+
+// funcUpgrades returns the changes in funcChanges made after the version of
+// terragrunt given by --from-version, up to v0.19. A function that was
+// renamed more than once is renamed straight to its newest name. Renames given
+// by --rename-function take precedence.
+func (c *command) funcUpgrades() (funcUpgrades, error) {
+	from := c.fromVersion
+	if from == "" {
+		from = defaultFromVersion
+	}
+
+	fv, err := parseVersion(from)
+	if err != nil {
+		return funcUpgrades{}, fmt.Errorf("invalid from version: %v", err)
+	}
+
+	// terragrunt was first configured in terraform.tfvars in v0.12
+	first, _ := parseVersion("0.12.0")
+	last, _ := parseVersion(defaultTargetVersion)
+	if compareVersions(fv, first) < 0 || compareVersions(fv, last) >= 0 {
+		return funcUpgrades{}, fmt.Errorf("invalid from version %s: must be >= %s and < %s", from, first, last)
+	}
+
+	fu := funcUpgrades{renames: make(map[string]string), removed: make(map[string]string)}
+	for _, ch := range funcChanges {
+		if v, _ := parseVersion(ch.version); compareVersions(v, fv) <= 0 || compareVersions(v, last) > 0 {
+			continue
+		}
+
+		for old, name := range fu.renames {
+			if name != ch.from {
+				continue
+			} else if ch.to == "" {
+				delete(fu.renames, old)
+				fu.removed[old] = ch.version
+			} else {
+				fu.renames[old] = ch.to
+			}
+		}
+		if ch.to == "" {
+			fu.removed[ch.from] = ch.version
+		} else {
+			fu.renames[ch.from] = ch.to
+		}
+	}
+
+	for _, r := range c.renameFunctions.values {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 || !hclv2syntax.ValidIdentifier(parts[0]) || !hclv2syntax.ValidIdentifier(parts[1]) {
+			return funcUpgrades{}, fmt.Errorf("invalid --rename-function %q. must be old=new, where both are function names", r)
+		}
+		fu.renames[parts[0]] = parts[1]
+		delete(fu.removed, parts[0])
+	}
+	return fu, nil
 }
 
-func upgradeFunctionNames(tokens hclv2write.Tokens) {
+// upgradeFunctionNames renames calls to the functions fu renames, and returns
+// a description of each rename. It also returns the names of any functions
+// called that fu lists as removed, which are left as they are.
+func upgradeFunctionNames(tokens hclv2write.Tokens, fu funcUpgrades) (renamed, removed []string) {
 	for i, t := range tokens {
 		if t.Type == hclv2syntax.TokenIdent {
-			newName, ok := renameFuncs[string(t.Bytes)]
+			if _, ok := fu.removed[string(t.Bytes)]; ok && i+1 < len(tokens) && tokens[i+1].Type == hclv2syntax.TokenOParen {
+				removed = append(removed, string(t.Bytes))
+				continue
+			}
+
+			newName, ok := fu.renames[string(t.Bytes)]
 			if !ok {
 				continue
 			}
 
-			if i+2 >= len(tokens)-1 {
+			if i+2 >= len(tokens) {
 				// need at least 2 more tokens in the expresion, '(' and ')', for this to be a valid function call
 				// since we don't have enough, continue
 				continue
@@ -748,7 +3916,10 @@ func upgradeFunctionNames(tokens hclv2write.Tokens) {
 				continue
 			}
 
+			renamed = append(renamed, fmt.Sprintf("%s -> %s", t.Bytes, newName))
 			t.Bytes = []byte(newName)
 		}
 	}
+
+	return renamed, removed
 }
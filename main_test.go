@@ -1,18 +1,39 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
+	hclv1ast "github.com/hashicorp/hcl/hcl/ast"
+	hclv1parser "github.com/hashicorp/hcl/hcl/parser"
+	hclv1token "github.com/hashicorp/hcl/hcl/token"
+	hclv2parse "github.com/hashicorp/hcl/v2/hclparse"
+	hclv2syntax "github.com/hashicorp/hcl/v2/hclsyntax"
+	hclv2write "github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/kylelemons/godebug/diff"
 )
 
 func TestUpgrade(t *testing.T) {
 	cases := []struct {
 		name        string
+		cmd         command
 		input       string
 		expected    string
 		expectedErr error
+		warnings    int
 	}{
 		{
 			name: "no terragrunt attribute",
@@ -309,10 +330,9 @@ inputs = {
       baz = "quux"
     },
     {
-      quux = <<EOF
-This is an indented heredoc
-EOF
-
+      quux = <<-EOF
+    This is an indented heredoc
+    EOF
     },
   ]
 
@@ -321,10 +341,10 @@ EOF
 
     echo "here's a shell script"
 EOF
-
 }
 `,
 			expectedErr: nil,
+			warnings:    1,
 		},
 		{
 			name: "rename functions",
@@ -364,23 +384,5719 @@ terraform {
 `,
 			expectedErr: nil,
 		},
-	}
+		{
+			name: "empty terragrunt attribute",
+			input: `
+terragrunt = {}
 
-	for _, c := range cases {
-		t.Run(c.name, func(t *testing.T) {
-			cmd := command{}
-			actual, err := cmd.upgrade([]byte(c.input))
-			if err != nil && c.expectedErr == nil {
-				t.Fatalf("unexpected error: %v", err)
-			} else if c.expectedErr != nil && err != c.expectedErr {
-				t.Fatalf("incorrect error: got=%v want=%v", err, c.expectedErr)
-			}
+domain = "app.foo.com"
+instance_type = "m5.xlarge"
+`,
+			expected: `
+inputs = {
+  domain        = "app.foo.com"
+  instance_type = "m5.xlarge"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "remote_state as a block",
+			cmd:  command{targetVersion: "0.19.2"},
+			input: `
+terragrunt = {
+  remote_state {
+    backend = "s3"
+    config {
+      bucket = "my-tfstate"
+    }
+  }
+}
+`,
+			expected: `
+remote_state {
+  backend = "s3"
 
-			// ditch the leading newline - used above to make the formatting a bit nicer
-			expected := strings.TrimLeft(c.expected, "\n")
-			if string(actual) != expected {
-				t.Errorf("incorrect result (-want, +got):\n%s\n", diff.Diff(string(actual), expected))
-			}
-		})
-	}
+  config = {
+    bucket = "my-tfstate"
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "remote_state as an attribute",
+			cmd:  command{targetVersion: "0.23"},
+			input: `
+terragrunt = {
+  remote_state {
+    backend = "s3"
+    config {
+      bucket = "my-tfstate"
+    }
+  }
+}
+`,
+			expected: `
+remote_state = {
+  backend = "s3"
+
+  config = {
+    bucket = "my-tfstate"
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "remote_state generate",
+			input: `
+terragrunt = {
+  remote_state {
+    backend = "s3"
+    generate {
+      path = "backend.tf"
+      if_exists = "overwrite_terragrunt"
+    }
+    config {
+      bucket = "my-tfstate"
+    }
+  }
+}
+`,
+			expected: `
+remote_state {
+  backend = "s3"
+
+  generate = {
+    path      = "backend.tf"
+    if_exists = "overwrite_terragrunt"
+  }
+
+  config = {
+    bucket = "my-tfstate"
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "remote_state generate as an attribute",
+			cmd:  command{targetVersion: "0.23"},
+			input: `
+terragrunt = {
+  remote_state = {
+    backend = "s3"
+    generate = {
+      path = "backend.tf"
+      if_exists = "overwrite_terragrunt"
+    }
+  }
+}
+`,
+			expected: `
+remote_state = {
+  backend = "s3"
+
+  generate = {
+    path      = "backend.tf"
+    if_exists = "overwrite_terragrunt"
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "multiple keys",
+			input: `
+terragrunt = {
+  terraform {
+    extra_arguments "args" {
+      commands = ["plan"]
+    }
+  }
+}
+
+tags "prod" "team" {
+  owner = "ops"
+}
+`,
+			expected: `
+terraform {
+  extra_arguments "args" {
+    commands = ["plan"]
+  }
+}
+
+inputs = {
+  tags = {
+    "prod" = {
+      "team" = {
+        owner = "ops"
+      }
+    }
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "heredoc at end of file",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+script = <<EOF
+echo "hello"
+EOF
+
+
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  script = <<EOF
+echo "hello"
+EOF
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "object at end of file",
+			input: `
+terragrunt = {
+  terraform {
+    source = "git::ssh://git@github.com/org/module.git//module?ref=master"
+  }
+}`,
+			expected: `
+terraform {
+  source = "git::ssh://git@github.com/org/module.git//module?ref=master"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "conditional interpolations",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+simple = "${var.enabled ? "a" : "b"}"
+nested = "${var.enabled ? "${var.a}-suffix" : "b"}"
+partial = "prefix-${var.enabled ? "a" : "b"}"
+empty = "${var.enabled ? "" : "b"}"
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  simple  = var.enabled ? "a" : "b"
+  nested  = var.enabled ? "${var.a}-suffix" : "b"
+  partial = "prefix-${var.enabled ? "a" : "b"}"
+  empty   = var.enabled ? "" : "b"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "comment styles",
+			input: `
+terragrunt = {
+  // slash lead
+  include {
+    path = "${find_in_parent_folders()}" // slash line
+  }
+
+  # hash lead
+  terraform {
+    source = "foo" # hash line
+  }
+
+  /* block lead */
+  iam_role = "foo" /* block line */
+
+  // slash detached
+
+  # hash detached
+
+  /* block detached */
+
+  /*
+   * multi-line block detached
+   */
+
+  skip = true
+}
+
+// slash input lead
+a = 1 // slash input line
+# hash input lead
+b = 2 # hash input line
+/* block input lead */
+c = 3 /* block input line */
+
+# hash input detached
+
+d = 4
+`,
+			expected: `
+// slash lead
+include {
+  path = find_in_parent_folders() // slash line
+}
+
+# hash lead
+terraform {
+  source = "foo" # hash line
+}
+
+/* block lead */
+iam_role = "foo" /* block line */
+
+// slash detached
+
+# hash detached
+
+/* block detached */
+
+/*
+   * multi-line block detached
+   */
+
+skip = true
+
+inputs = {
+  // slash input lead
+  a = 1 // slash input line
+
+  # hash input lead
+  b = 2 # hash input line
+
+  /* block input lead */
+  c = 3 /* block input line */
+
+  # hash input detached
+
+  d = 4
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "hooks",
+			input: `
+terragrunt = {
+  terraform {
+    before_hook "before" {
+      commands = ["apply", "plan"]
+      execute  = ["echo", "before"]
+    }
+
+    after_hook "after" {
+      commands     = ["apply"]
+      execute      = ["echo", "after"]
+      run_on_error = true
+    }
+  }
+}
+`,
+			expected: `
+terraform {
+  before_hook "before" {
+    commands = ["apply", "plan"]
+    execute  = ["echo", "before"]
+  }
+
+  after_hook "after" {
+    commands     = ["apply"]
+    execute      = ["echo", "after"]
+    run_on_error = true
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "interpolations in lists",
+			input: `
+terragrunt = {
+  terraform {
+    extra_arguments "args" {
+      required_var_files = ["${get_tfvars_dir()}/a.tfvars", "${get_parent_tfvars_dir()}"]
+
+      optional_var_files = [
+        "${get_tfvars_dir()}",
+        "${find_in_parent_folders("common.tfvars")}",
+      ]
+    }
+  }
+}
+
+dirs = ["${get_tfvars_dir()}", "${get_parent_tfvars_dir()}/b"]
+`,
+			expected: `
+terraform {
+  extra_arguments "args" {
+    required_var_files = ["${get_terragrunt_dir()}/a.tfvars", get_parent_terragrunt_dir()]
+
+    optional_var_files = [
+      get_terragrunt_dir(),
+      find_in_parent_folders("common.tfvars"),
+    ]
+  }
+}
+
+inputs = {
+  dirs = [get_terragrunt_dir(), "${get_parent_terragrunt_dir()}/b"]
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "comments above inputs",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+// comment above the first input
+
+domain = "app.foo.com"
+
+// comment between inputs
+
+instance_type = "m5.xlarge"
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  // comment above the first input
+
+  domain = "app.foo.com"
+
+  // comment between inputs
+
+  instance_type = "m5.xlarge"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "no inputs block",
+			cmd:  command{noInputsBlock: true},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+domain = "app.foo.com"
+instance_type = "m5.xlarge"
+
+terraform = {
+  version = "0.12.28"
+}
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+domain        = "app.foo.com"
+instance_type = "m5.xlarge"
+
+terraform = {
+  version = "0.12.28"
+}
+`,
+			expectedErr: nil,
+			warnings:    1,
+		},
+		{
+			name: "numbers",
+			input: `
+terragrunt = {
+  iam_assume_role_duration = 3600
+}
+
+int = 1000
+negative = -5
+exponent = 1e6
+float = -2.5e-3
+hex = 0x1F
+octal = 010
+`,
+			expected: `
+iam_assume_role_duration = 3600
+
+inputs = {
+  int      = 1000
+  negative = -5
+  exponent = 1e6
+  float    = -2.5e-3
+  hex      = 31
+  octal    = 8
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "comments in lists",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+list = [
+  "a", # line comment on a
+  # lead comment on b
+  "b",
+
+  # detached comment
+
+  "c", // line comment on c
+  /* block lead on d */
+  "d"
+]
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  list = [
+    "a", # line comment on a
+    # lead comment on b
+    "b",
+
+    # detached comment
+
+    "c", // line comment on c
+    /* block lead on d */
+    "d",
+  ]
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "include with extra attributes",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+    expose = true
+    merge_strategy = "deep"
+  }
+}
+`,
+			expected: `
+include {
+  path           = find_in_parent_folders()
+  expose         = true
+  merge_strategy = "deep"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "escape sequences",
+			input: `
+terragrunt = {}
+
+quoted = "a \"quoted\" b"
+windows_path = "C:\\Users\\foo"
+regex = "^[a-z]+\\.tf$"
+whitespace = "a\tb\n"
+unicode = "caf\u00e9"
+trailing_backslash = "foo\\"
+escaped_interp = "$${not_interpolated}"
+interp = "${get_env("HOME", "C:\\Users\\\"foo\"")}"
+mixed = "${get_env("HOME", "")}\\bin"
+list = ["a\\", "\"b\""]
+directive = "100%{x}"
+heredoc = <<EOF
+100%{x}
+EOF
+`,
+			expected: `
+inputs = {
+  quoted             = "a \"quoted\" b"
+  windows_path       = "C:\\Users\\foo"
+  regex              = "^[a-z]+\\.tf$"
+  whitespace         = "a\tb\n"
+  unicode            = "caf\u00e9"
+  trailing_backslash = "foo\\"
+  escaped_interp     = "$${not_interpolated}"
+  interp             = get_env("HOME", "C:\\Users\\\"foo\"")
+  mixed              = "${get_env("HOME", "")}\\bin"
+  list               = ["a\\", "\"b\""]
+  directive          = "100%%{x}"
+  heredoc            = <<EOF
+100%%{x}
+EOF
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "only some settings",
+			cmd:  command{quiet: true, only: "remote_state, dependencies"},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+
+  # remote state
+  remote_state {
+    backend = "s3"
+  }
+
+  terraform {
+    source = "git::git@github.com:foo/bar.git"
+  }
+
+  dependencies {
+    paths = ["../vpc"]
+  }
+}
+
+foo = "bar"
+`,
+			expected: `
+# remote state
+remote_state {
+  backend = "s3"
+}
+
+dependencies {
+  paths = ["../vpc"]
+}
+
+inputs = {
+  foo = "bar"
+}
+`,
+			expectedErr: nil,
+			warnings:    2,
+		},
+		{
+			name: "indented heredocs",
+			input: `
+terragrunt = {}
+
+indented = <<-EOF
+    line 1
+      line 2
+    EOF
+
+nested = {
+  heredoc = <<-EOT
+  nested line
+  EOT
+}
+
+# hcl v2 would strip the indentation differently from hcl v1
+deeper_than_marker = <<-EOF
+      line 1
+    EOF
+`,
+			expected: `
+inputs = {
+  indented = <<-EOF
+    line 1
+      line 2
+    EOF
+
+  nested = {
+    heredoc = <<-EOT
+  nested line
+  EOT
+  }
+
+  # hcl v2 would strip the indentation differently from hcl v1
+  deeper_than_marker = <<EOF
+  line 1
+EOF
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "get_env",
+			input: `
+terragrunt = {
+  remote_state {
+    backend = "s3"
+    config {
+      bucket = "${get_env("BUCKET", "my-bucket")}"
+      key = "${get_env("PREFIX", "")}/${path_relative_to_include()}/terraform.tfstate"
+    }
+  }
+}
+
+region = "${get_env("AWS_REGION", "us-east-1")}"
+one_arg = "${get_env("HOME")}"
+empty_default = "${get_env("HOME", "")}"
+nested = "${get_env("A", "${get_env("B", "c")}")}"
+`,
+			expected: `
+remote_state {
+  backend = "s3"
+
+  config = {
+    bucket = get_env("BUCKET", "my-bucket")
+    key    = "${get_env("PREFIX", "")}/${path_relative_to_include()}/terraform.tfstate"
+  }
+}
+
+inputs = {
+  region        = get_env("AWS_REGION", "us-east-1")
+  one_arg       = get_env("HOME")
+  empty_default = get_env("HOME", "")
+  nested        = get_env("A", "${get_env("B", "c")}")
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "keep interpolation",
+			cmd:  command{keepInterpolation: true},
+			input: `
+terragrunt = {
+  terraform {
+    extra_arguments "args" {
+      required_var_files = ["${get_tfvars_dir()}/common.tfvars", "${get_parent_tfvars_dir()}"]
+    }
+  }
+}
+
+region = "${get_env("AWS_REGION", "us-east-1")}"
+`,
+			expected: `
+terraform {
+  extra_arguments "args" {
+    required_var_files = ["${get_terragrunt_dir()}/common.tfvars", "${get_parent_terragrunt_dir()}"]
+  }
+}
+
+inputs = {
+  region = "${get_env("AWS_REGION", "us-east-1")}"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "comments in extra_arguments",
+			input: `
+terragrunt = {
+  terraform {
+    # args for every command
+    extra_arguments "common" { # line on block
+      # the commands
+      commands = ["${get_terraform_commands_that_need_vars()}"]
+
+      # detached inside extra_arguments
+
+      /* the arguments */
+      arguments = [
+        "-var-file=${get_tfvars_dir()}/common.tfvars", # common vars
+      ]
+      env_vars = {
+        # lead on env var
+        TF_VAR_foo = "bar" // line on env var
+      }
+      # trailing comment in block
+    }
+  }
+}
+`,
+			expected: `
+terraform {
+  # args for every command
+  extra_arguments "common" { # line on block
+    # the commands
+    commands = [get_terraform_commands_that_need_vars()]
+
+    # detached inside extra_arguments
+
+    /* the arguments */
+    arguments = [
+      "-var-file=${get_terragrunt_dir()}/common.tfvars", # common vars
+    ]
+
+    env_vars = {
+      # lead on env var
+      TF_VAR_foo = "bar" // line on env var
+    }
+
+    # trailing comment in block
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "blocks written as blocks",
+			input: `
+terragrunt = {
+  remote_state {
+    backend = "s3"
+    config = {
+      bucket = "b"
+    }
+  }
+  terraform {
+    source = "git::git@github.com:foo/bar.git"
+    extra_arguments "args" {
+      commands = ["plan"]
+    }
+  }
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`,
+			expected: `
+remote_state {
+  backend = "s3"
+
+  config = {
+    bucket = "b"
+  }
+}
+
+terraform {
+  source = "git::git@github.com:foo/bar.git"
+
+  extra_arguments "args" {
+    commands = ["plan"]
+  }
+}
+
+include {
+  path = find_in_parent_folders()
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "blocks written as attributes",
+			input: `
+terragrunt = {
+  remote_state = {
+    backend = "s3"
+    config = {
+      bucket = "b"
+    }
+  }
+  terraform = {
+    source = "git::git@github.com:foo/bar.git"
+    extra_arguments "args" {
+      commands = ["plan"]
+    }
+  }
+  include = {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`,
+			expected: `
+remote_state {
+  backend = "s3"
+
+  config = {
+    bucket = "b"
+  }
+}
+
+terraform {
+  source = "git::git@github.com:foo/bar.git"
+
+  extra_arguments "args" {
+    commands = ["plan"]
+  }
+}
+
+include {
+  path = find_in_parent_folders()
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "lead comments on consecutive blocks",
+			input: `
+terragrunt = {
+  # include lead
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+  # remote state lead
+  remote_state {
+    backend = "s3"
+  }
+
+  /*
+   * terraform lead
+   */
+  terraform {
+    # hook lead
+    before_hook "a" {
+      commands = ["plan"]
+      execute = ["echo"]
+    }
+    # second hook lead
+    after_hook "b" {
+      commands = ["plan"]
+      execute = ["echo"]
+    }
+  }
+
+  # detached
+
+  // dependencies lead
+  // second line
+  dependencies {
+    paths = ["../a"]
+  }
+}
+`,
+			expected: `
+# include lead
+include {
+  path = find_in_parent_folders()
+}
+
+# remote state lead
+remote_state {
+  backend = "s3"
+}
+
+/*
+   * terraform lead
+   */
+terraform {
+  # hook lead
+  before_hook "a" {
+    commands = ["plan"]
+    execute  = ["echo"]
+  }
+
+  # second hook lead
+  after_hook "b" {
+    commands = ["plan"]
+    execute  = ["echo"]
+  }
+}
+
+# detached
+
+// dependencies lead
+// second line
+dependencies {
+  paths = ["../a"]
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "lead comments on consecutive objects",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+# first map
+first = {
+  a = 1
+}
+# second map
+second = {
+  b = 2
+}
+# scalar
+scalar = 1
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  # first map
+  first = {
+    a = 1
+  }
+
+  # second map
+  second = {
+    b = 2
+  }
+
+  # scalar
+  scalar = 1
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "keys that need quoting",
+			input: `
+terragrunt = {
+  terraform {
+    extra_arguments "args" {
+      commands = ["plan"]
+      env_vars = {
+        for = "x"
+      }
+    }
+  }
+}
+
+foo-bar = 1
+foo.bar = 2
+for = 3
+"1abc" = 4
+"with space" = 5
+map = {
+  nested.key = 6
+}
+`,
+			expected: `
+terraform {
+  extra_arguments "args" {
+    commands = ["plan"]
+
+    env_vars = {
+      "for" = "x"
+    }
+  }
+}
+
+inputs = {
+  foo-bar      = 1
+  "foo.bar"    = 2
+  "for"        = 3
+  "1abc"       = 4
+  "with space" = 5
+
+  map = {
+    "nested.key" = 6
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "sort inputs",
+			cmd:  command{sortInputs: true},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+# detached before zeta
+
+zeta = "z"
+# alpha lead
+alpha = "a" # alpha line
+
+map = {
+  # detached inside map
+
+  b = 2
+  a = 1
+}
+
+"beta" = [
+  "x",
+]
+
+# end of file
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+# detached before zeta
+
+inputs = {
+  # alpha lead
+  alpha = "a" # alpha line
+
+  "beta" = [
+    "x",
+  ]
+
+  map = {
+    # detached inside map
+
+    b = 2
+    a = 1
+  }
+
+  zeta = "z"
+}
+
+# end of file
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "sort inputs without an inputs block",
+			cmd:  command{sortInputs: true, noInputsBlock: true},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+zeta = "z"
+alpha = "a"
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+alpha = "a"
+zeta  = "z"
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "dependencies and dependency blocks",
+			input: `
+terragrunt = {
+  dependencies {
+    paths = ["../vpc", "../mysql"]
+  }
+
+  dependency "vpc" {
+    config_path = "../vpc"
+    mock_outputs = {
+      vpc_id = "mock"
+    }
+  }
+
+  dependency "mysql" {
+    config_path = "../mysql"
+  }
+}
+
+vpc_id = "${dependency.vpc.outputs.vpc_id}"
+`,
+			expected: `
+dependencies {
+  paths = ["../vpc", "../mysql"]
+}
+
+dependency "vpc" {
+  config_path = "../vpc"
+
+  mock_outputs = {
+    vpc_id = "mock"
+  }
+}
+
+dependency "mysql" {
+  config_path = "../mysql"
+}
+
+inputs = {
+  vpc_id = dependency.vpc.outputs.vpc_id
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "unary and arithmetic interpolations",
+			input: `
+terragrunt = {}
+
+negative = "${-var.count}"
+sum = "${1 + 2}"
+negative_local = "${-local.x}"
+mixed = "${var.a * -2 / (3 - var.b) % 4}"
+comparison = "${var.a >= 1 && var.b != 2}"
+conditional = "${var.a > 0 ? -1 : 1}"
+`,
+			expected: `
+inputs = {
+  negative       = -var.count
+  sum            = 1 + 2
+  negative_local = -local.x
+  mixed          = var.a * -2 / (3 - var.b) % 4
+  comparison     = var.a >= 1 && var.b != 2
+  conditional    = var.a > 0 ? -1 : 1
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "footer comment after blocks",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+# footer comment
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+# footer comment
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "footer comments after inputs",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+foo = "bar" # line comment
+
+
+/* footer comment */
+// second line
+
+# second footer comment
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  foo = "bar" # line comment
+}
+
+/* footer comment */
+// second line
+
+# second footer comment
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "footer comment without an inputs block",
+			cmd:  command{noInputsBlock: true},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+foo = "bar"
+# footer comment
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+foo = "bar"
+
+# footer comment
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "only comments outside of terragrunt",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+# foo = "bar"
+
+/* bar = "baz" */
+# baz = 1
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+# foo = "bar"
+
+/* bar = "baz" */
+# baz = 1
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "remote_state interpolations mixed with text",
+			input: `
+terragrunt = {
+  remote_state {
+    backend = "s3"
+    config {
+      bucket = "${get_env("TG_BUCKET_PREFIX", "")}-terraform-state"
+      key    = "${path_relative_to_include()}/${get_env("ENV", "dev")}/terraform.tfstate"
+      region = "${get_aws_account_id()}${get_env("SUFFIX", "")}"
+      table  = "locks-${get_env("ENV", "dev")}"
+    }
+  }
+}
+`,
+			expected: `
+remote_state {
+  backend = "s3"
+
+  config = {
+    bucket = "${get_env("TG_BUCKET_PREFIX", "")}-terraform-state"
+    key    = "${path_relative_to_include()}/${get_env("ENV", "dev")}/terraform.tfstate"
+    region = "${get_aws_account_id()}${get_env("SUFFIX", "")}"
+    table  = "locks-${get_env("ENV", "dev")}"
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "remote_state config written as a block",
+			input: `
+terragrunt = {
+  remote_state {
+    backend = "s3"
+    config {
+      bucket = "my-bucket"
+      s3_bucket_tags {
+        owner = "me"
+      }
+    }
+  }
+}
+`,
+			expected: `
+remote_state {
+  backend = "s3"
+
+  config = {
+    bucket = "my-bucket"
+
+    s3_bucket_tags = {
+      owner = "me"
+    }
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "remote_state config written as an attribute",
+			input: `
+terragrunt = {
+  remote_state {
+    backend = "s3"
+    config = {
+      bucket = "my-bucket"
+      s3_bucket_tags = {
+        owner = "me"
+      }
+    }
+  }
+}
+`,
+			expected: `
+remote_state {
+  backend = "s3"
+
+  config = {
+    bucket = "my-bucket"
+
+    s3_bucket_tags = {
+      owner = "me"
+    }
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "nested maps in remote_state config",
+			input: `
+terragrunt = {
+  remote_state {
+    backend = "s3"
+    config {
+      bucket = "my-bucket"
+      assume_role {
+        role_arn = "arn:aws:iam::123456789012:role/terraform"
+        tags {
+          team = "infra"
+          nested {
+            deeper = true
+          }
+        }
+        policy_arns = ["arn:aws:iam::aws:policy/ReadOnlyAccess"]
+      }
+    }
+  }
+}
+`,
+			expected: `
+remote_state {
+  backend = "s3"
+
+  config = {
+    bucket = "my-bucket"
+
+    assume_role = {
+      role_arn = "arn:aws:iam::123456789012:role/terraform"
+
+      tags = {
+        team = "infra"
+
+        nested = {
+          deeper = true
+        }
+      }
+
+      policy_arns = ["arn:aws:iam::aws:policy/ReadOnlyAccess"]
+    }
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "empty lists and objects",
+			input: `
+terragrunt = {
+  remote_state = {}
+  terraform {
+    extra_arguments "args" {
+      commands = []
+      arguments = []
+    }
+  }
+}
+
+empty_list = []
+empty_map = {}
+nested = {
+  inner = {}
+  list = []
+}
+multiline_list = [
+]
+commented_list = [
+  # nothing yet
+]
+commented_map = {
+  # nothing yet
+}
+last = 1
+`,
+			expected: `
+remote_state {}
+
+terraform {
+  extra_arguments "args" {
+    commands  = []
+    arguments = []
+  }
+}
+
+inputs = {
+  empty_list = []
+  empty_map  = {}
+
+  nested = {
+    inner = {}
+    list  = []
+  }
+
+  multiline_list = []
+
+  commented_list = [
+    # nothing yet
+  ]
+
+  commented_map = {
+    # nothing yet
+  }
+
+  last = 1
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "quoted map keys",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+tags = {
+  "kubernetes.io/cluster/main" = "owned"
+  "with space" = "a"
+  "quote\"d" = "b"
+  "interp-${var.env}" = "c"
+  "plain" = "d"
+  "for" = "e"
+  "50%{off}" = "f"
+}
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  tags = {
+    "kubernetes.io/cluster/main" = "owned"
+    "with space"                 = "a"
+    "quote\"d"                   = "b"
+    "interp-${var.env}"          = "c"
+    "plain"                      = "d"
+    "for"                        = "e"
+    "50%%{off}"                  = "f"
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "rename functions in heredocs",
+			cmd:  command{renameInHeredocs: true},
+			input: `
+terragrunt = {
+  terraform {
+    extra_arguments "args" {
+      commands = ["plan"]
+      env_vars = {
+        POLICY = <<EOF
+{
+  "dir": "${get_tfvars_dir()}/${get_parent_tfvars_dir()}",
+  "literal": "get_tfvars_dir()",
+  "escaped": "$${get_tfvars_dir()}"
+}
+EOF
+      }
+    }
+  }
+}
+`,
+			expected: `
+terraform {
+  extra_arguments "args" {
+    commands = ["plan"]
+
+    env_vars = {
+      POLICY = <<EOF
+{
+  "dir": "${get_terragrunt_dir()}/${get_parent_terragrunt_dir()}",
+  "literal": "get_tfvars_dir()",
+  "escaped": "$${get_tfvars_dir()}"
+}
+EOF
+    }
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "multiple extra_arguments blocks",
+			input: `
+terragrunt = {
+  terraform {
+    source = "git::git@github.com:foo/modules.git//app"
+    extra_arguments "common_vars" {
+      commands = ["${get_terraform_commands_that_need_vars()}"]
+      optional_var_files = ["${get_tfvars_dir()}/../common.tfvars"]
+    }
+    extra_arguments "retry_lock" {
+      commands  = ["${get_terraform_commands_that_need_locking()}"]
+      arguments = ["-lock-timeout=20m"]
+    }
+    # parallelism
+    extra_arguments "parallelism" {
+      commands  = ["apply", "plan"]
+      arguments = ["-parallelism=5"]
+    }
+  }
+}
+`,
+			expected: `
+terraform {
+  source = "git::git@github.com:foo/modules.git//app"
+
+  extra_arguments "common_vars" {
+    commands           = [get_terraform_commands_that_need_vars()]
+    optional_var_files = ["${get_terragrunt_dir()}/../common.tfvars"]
+  }
+
+  extra_arguments "retry_lock" {
+    commands  = [get_terraform_commands_that_need_locking()]
+    arguments = ["-lock-timeout=20m"]
+  }
+
+  # parallelism
+  extra_arguments "parallelism" {
+    commands  = ["apply", "plan"]
+    arguments = ["-parallelism=5"]
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "single element lists",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+inline = ["a"]
+inline_trailing = ["a",]
+multiline = [
+  "a"
+]
+multiline_trailing = [
+  "a",
+]
+multiline_comment = [
+  "a", # why
+]
+mixed = ["a"
+]
+numbers = [
+  1]
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  inline          = ["a"]
+  inline_trailing = ["a"]
+
+  multiline = [
+    "a",
+  ]
+
+  multiline_trailing = [
+    "a",
+  ]
+
+  multiline_comment = [
+    "a", # why
+  ]
+
+  mixed = [
+    "a",
+  ]
+
+  numbers = [
+    1,
+  ]
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "no formatting",
+			cmd:  command{noFormat: true},
+			input: `
+terragrunt = {
+  terraform {
+    source = "git::git@github.com:foo/modules.git//app"
+    extra_arguments "common_vars" {
+      commands = ["${get_terraform_commands_that_need_vars()}"]
+      optional_var_files = ["${get_tfvars_dir()}/../common.tfvars"]
+    }
+    extra_arguments "retry_lock" {
+      commands  = ["${get_terraform_commands_that_need_locking()}"]
+      arguments = ["-lock-timeout=20m"]
+    }
+    # parallelism
+    extra_arguments "parallelism" {
+      commands  = ["apply", "plan"]
+      arguments = ["-parallelism=5"]
+    }
+  }
+}
+`,
+			expected: `
+terraform {
+  source = "git::git@github.com:foo/modules.git//app"
+
+  extra_arguments "common_vars" {
+    commands = [get_terraform_commands_that_need_vars()]
+    optional_var_files = ["${get_terragrunt_dir()}/../common.tfvars"]
+}
+
+  extra_arguments "retry_lock" {
+    commands = [get_terraform_commands_that_need_locking()]
+    arguments = ["-lock-timeout=20m"]
+}
+
+  # parallelism
+  extra_arguments "parallelism" {
+    commands = ["apply", "plan"]
+    arguments = ["-parallelism=5"]
+}
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "inputs before terragrunt",
+			input: `
+# header comment
+
+# about foo
+foo = "bar"
+
+# detached between inputs
+
+baz = 1 # line comment
+
+# before terragrunt
+
+terragrunt = {
+  # about include
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+
+  # detached in terragrunt
+}
+
+# after terragrunt
+qux = true
+
+# footer
+`,
+			expected: `
+# header comment
+
+# before terragrunt
+
+# about include
+include {
+  path = find_in_parent_folders()
+}
+
+# detached in terragrunt
+
+inputs = {
+  # about foo
+  foo = "bar"
+
+  # detached between inputs
+
+  baz = 1 # line comment
+
+  # after terragrunt
+  qux = true
+}
+
+# footer
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "single-line list spacing",
+			input: `
+terragrunt = {
+  terraform {
+    extra_arguments "args" {
+      commands = ["plan","apply"]
+      arguments = [ "-lock=false" , "-input=false" ]
+    }
+  }
+}
+
+ports = [80,443]
+spaced = [  1  ,   2,3  ]
+nested = [[1,2],["a",  "b"]]
+`,
+			expected: `
+terraform {
+  extra_arguments "args" {
+    commands  = ["plan", "apply"]
+    arguments = ["-lock=false", "-input=false"]
+  }
+}
+
+inputs = {
+  ports  = [80, 443]
+  spaced = [1, 2, 3]
+  nested = [[1, 2], ["a", "b"]]
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "single-line list spacing without formatting",
+			cmd:  command{noFormat: true},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+ports = [80,443]
+spaced = [  1  ,   2,3  ]
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  ports = [80, 443]
+  spaced = [1, 2, 3]
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "dotted keys",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+tags.Name = "x"
+"a.b" = 1
+tags = {
+  cost.center = "eng"
+}
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  "tags.Name" = "x"
+  "a.b"       = 1
+
+  tags = {
+    "cost.center" = "eng"
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "merge and concat interpolations",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+tags = "${merge(var.a, var.b)}"
+subnets = "${concat(var.public, var.private)}"
+nested = "${merge(var.a, map("x", lookup(var.b, "y")))}"
+nested_interp = "${merge(var.a, {x = "${var.y}"})}"
+nested_dir = "${concat(list("${get_tfvars_dir()}/a"), var.b)}"
+mixed = "prefix-${join("-", concat(var.a, var.b))}"
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  tags          = merge(var.a, var.b)
+  subnets       = concat(var.public, var.private)
+  nested        = merge(var.a, map("x", lookup(var.b, "y")))
+  nested_interp = merge(var.a, { x = "${var.y}" })
+  nested_dir    = concat(list("${get_terragrunt_dir()}/a"), var.b)
+  mixed         = "prefix-${join("-", concat(var.a, var.b))}"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "custom function renames",
+			cmd:  command{renameFunctions: stringList{values: []string{"get_account=get_aws_account_id", "get_tfvars_dir=get_parent_terragrunt_dir"}}},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+account = "${get_account()}"
+dir = "${get_tfvars_dir()}"
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  account = get_aws_account_id()
+  dir     = get_parent_terragrunt_dir()
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "comments between keys and values",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+foo = # inline
+  "bar"
+
+baz = /* block */ 1
+
+qux = # about the list
+[
+  1,
+  2,
+]
+
+obj = # about the object
+{
+  a = 1
+}
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  foo = "bar" # inline
+
+  /* block */
+  baz = 1
+
+  # about the list
+  qux = [
+    1,
+    2,
+  ]
+
+  # about the object
+  obj = {
+    a = 1
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "numeric and boolean settings",
+			input: `
+terragrunt = {
+  iam_role = "arn:aws:iam::123456789012:role/terragrunt"
+  iam_assume_role_duration = 3600
+  prevent_destroy = true
+  skip = false
+  retry_max_attempts = 5
+  retry_sleep_interval_sec = 0x1E
+  terraform_version_constraint = ">= 0.12"
+
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+region = "us-east-1"
+`,
+			expected: `
+iam_role                     = "arn:aws:iam::123456789012:role/terragrunt"
+iam_assume_role_duration     = 3600
+prevent_destroy              = true
+skip                         = false
+retry_max_attempts           = 5
+retry_sleep_interval_sec     = 30
+terraform_version_constraint = ">= 0.12"
+
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  region = "us-east-1"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "string boolean settings",
+			input: `
+terragrunt = {
+  prevent_destroy = "true"
+  skip = "false"
+}
+
+enabled = "true"
+`,
+			expected: `
+prevent_destroy = true
+skip            = false
+
+inputs = {
+  enabled = "true"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "string boolean settings that aren't bools",
+			input: `
+terragrunt = {
+  prevent_destroy = "${var.protected}"
+  skip = "maybe"
+}
+`,
+			expected: `
+prevent_destroy = var.protected
+skip            = "maybe"
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "key order",
+			input: `
+terragrunt = {
+  remote_state {
+    config {
+      region = "us-east-1"
+      key = "${path_relative_to_include()}/terraform.tfstate"
+      bucket = "my-terraform-state"
+    }
+    backend = "s3"
+  }
+}
+
+zone = "a"
+autoscale_config = {
+  min = 1
+  max = 3
+  desired = 2
+}
+app = {
+  name = "web"
+  env = {
+    ZED = "z"
+    ALPHA = "a"
+  }
+}
+`,
+			expected: `
+remote_state {
+  config = {
+    region = "us-east-1"
+    key    = "${path_relative_to_include()}/terraform.tfstate"
+    bucket = "my-terraform-state"
+  }
+
+  backend = "s3"
+}
+
+inputs = {
+  zone = "a"
+
+  autoscale_config = {
+    min     = 1
+    max     = 3
+    desired = 2
+  }
+
+  app = {
+    name = "web"
+
+    env = {
+      ZED   = "z"
+      ALPHA = "a"
+    }
+  }
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "key order with sorted inputs",
+			cmd:  command{sortInputs: true},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+zone = "a"
+autoscale_config = {
+  min = 1
+  max = 3
+  desired = 2
+}
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  autoscale_config = {
+    min     = 1
+    max     = 3
+    desired = 2
+  }
+
+  zone = "a"
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "alignment groups",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+a = 1
+bbbbbb = 2
+
+# after a blank line
+cc = 3
+dddddddddd = 4
+# after a comment
+e = 5
+ffff = 6 # line comments don't end a group
+ggggggggggggg = 7
+/* block comment */
+h = 8
+iiii = {
+  x = 1
+}
+j = 9
+kkkkk = [
+  1,
+]
+l = 10
+mmmm = "x"
+nn = <<EOF
+long = heredoc
+EOF
+o = 11
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  a      = 1
+  bbbbbb = 2
+
+  # after a blank line
+  cc         = 3
+  dddddddddd = 4
+
+  # after a comment
+  e             = 5
+  ffff          = 6 # line comments don't end a group
+  ggggggggggggg = 7
+
+  /* block comment */
+  h = 8
+
+  iiii = {
+    x = 1
+  }
+
+  j = 9
+
+  kkkkk = [
+    1,
+  ]
+
+  l    = 10
+  mmmm = "x"
+  nn   = <<EOF
+long = heredoc
+EOF
+
+  o = 11
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "removed settings",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+
+  # locking
+  lock = {
+    backend = "dynamodb"
+
+    # the table
+    config {
+      state_file_id = "app"
+    }
+  }
+
+  prevent_destroy = true
+}
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+prevent_destroy = true
+`,
+			expectedErr: nil,
+			warnings:    1,
+		},
+		{
+			name: "removed settings commented out",
+			cmd:  command{removedSettings: "comment"},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+
+  # locking
+  lock = {
+    backend = "dynamodb"
+
+    # the table
+    config {
+      state_file_id = "app"
+    }
+  }
+
+  prevent_destroy = true
+}
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+# locking
+# TODO: lock is not supported by terragrunt v0.19. terragrunt v0.18 ignored it with a warning, since locking is configured in the terraform backend
+# lock = {
+#   backend = "dynamodb"
+#
+#   # the table
+#   config {
+#     state_file_id = "app"
+#   }
+# }
+
+prevent_destroy = true
+`,
+			expectedErr: nil,
+			warnings:    1,
+		},
+		{
+			name: "removed heredoc setting commented out",
+			cmd:  command{removedSettings: "comment"},
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+
+  lock = <<EOF
+backend = "dynamodb"
+EOF
+
+  prevent_destroy = true
+}
+`,
+			expected: `
+include {
+  path = find_in_parent_folders()
+}
+
+# TODO: lock is not supported by terragrunt v0.19. terragrunt v0.18 ignored it with a warning, since locking is configured in the terraform backend
+# lock = <<EOF
+# backend = "dynamodb"
+# EOF
+
+prevent_destroy = true
+`,
+			expectedErr: nil,
+			warnings:    1,
+		},
+		{
+			name: "indent with tabs",
+			cmd:  command{indent: "tab"},
+			input: `
+terragrunt = {
+  terraform {
+    extra_arguments "args" {
+      commands = ["plan"]
+    }
+  }
+}
+
+script = <<EOF
+  echo "indented"
+EOF
+`,
+			expected: `
+terraform {
+	extra_arguments "args" {
+		commands = ["plan"]
+	}
+}
+
+inputs = {
+	script = <<EOF
+  echo "indented"
+EOF
+}
+`,
+			expectedErr: nil,
+		},
+		{
+			name: "indent with 4 spaces",
+			cmd:  command{indent: "4"},
+			input: `
+terragrunt = {
+  /*
+   * block comment
+   */
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+tags = {
+  name = "foo"
+}
+`,
+			expected: `
+/*
+   * block comment
+   */
+include {
+    path = find_in_parent_folders()
+}
+
+inputs = {
+    tags = {
+        name = "foo"
+    }
+}
+`,
+			expectedErr: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := c.cmd
+			var (
+				actual []byte
+				err    error
+			)
+			_, stderr := captureOutput(t, func() {
+				actual, err = cmd.upgrade([]byte(c.input))
+			})
+			if err != nil && c.expectedErr == nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if c.expectedErr != nil && err != c.expectedErr {
+				t.Fatalf("incorrect error: got=%v want=%v", err, c.expectedErr)
+			}
+			if cmd.warnings != c.warnings {
+				t.Errorf("incorrect number of warnings: got=%d want=%d\n%s", cmd.warnings, c.warnings, stderr)
+			}
+
+			// ditch the leading newline - used above to make the formatting a bit nicer
+			expected := strings.TrimLeft(c.expected, "\n")
+			if string(actual) != expected {
+				t.Errorf("incorrect result (-want, +got):\n%s\n", diff.Diff(string(actual), expected))
+			}
+		})
+	}
+}
+
+func TestUpgradeIdempotent(t *testing.T) {
+	input := `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+domain = "app.foo.com"
+`
+
+	cmd := command{}
+	if isUpgraded([]byte(input)) {
+		t.Fatalf("terragrunt <= v0.18 config detected as upgraded")
+	}
+
+	upgraded, err := cmd.upgrade([]byte(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !isUpgraded(upgraded) {
+		t.Fatalf("upgraded config not detected as upgraded:\n%s", upgraded)
+	}
+
+	if _, err := cmd.upgrade(upgraded); err == nil {
+		t.Errorf("expected an error upgrading an upgraded config")
+	}
+}
+
+func TestRunIdempotent(t *testing.T) {
+	files := map[string]string{
+		"terraform.tfvars": `terragrunt = {
+  remote_state {
+    backend = "s3"
+  }
+}
+`,
+		"mod/terraform.tfvars": `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+domain = "app.foo.com"
+`,
+	}
+
+	cases := []struct {
+		name string
+		cmd  command
+		args []string
+	}{
+		{name: "recursive", cmd: command{recursive: true, allowUnbounded: true}, args: []string{"."}},
+		{name: "keep originals", cmd: command{recursive: true, allowUnbounded: true, keepOld: true}, args: []string{"."}},
+		{name: "in place", cmd: command{inPlace: true}, args: []string{"terraform.tfvars", "mod/terraform.tfvars"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chdir(t, writeTree(t, files))
+
+			first := c.cmd
+			if _, stderr, err := captureRun(t, &first, c.args...); err != nil {
+				t.Fatalf("unexpected error upgrading: %v\n%s", err, stderr)
+			}
+			upgraded := readTree(t, ".")
+			if reflect.DeepEqual(upgraded, files) {
+				t.Fatalf("nothing was upgraded")
+			}
+
+			second := c.cmd
+			if _, stderr, err := captureRun(t, &second, c.args...); err != nil {
+				t.Fatalf("unexpected error upgrading again: %v\n%s", err, stderr)
+			}
+			if actual := readTree(t, "."); !reflect.DeepEqual(actual, upgraded) {
+				t.Errorf("second run changed the files: got=%q want=%q", actual, upgraded)
+			}
+		})
+	}
+}
+
+func TestIsUpgraded(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{
+			name:     "terragrunt <= v0.18",
+			input:    "terragrunt = {\n  include {\n    path = \"${find_in_parent_folders()}\"\n  }\n}\n",
+			expected: false,
+		},
+		{
+			name:     "plain tfvars",
+			input:    "region = \"us-east-1\"\ntags = {\n  team = \"eng\"\n}\n",
+			expected: false,
+		},
+		{
+			name:     "empty",
+			input:    "",
+			expected: false,
+		},
+		{
+			name:     "not hcl v2",
+			input:    "include {\n  path = \"${find_in_parent_folders()\"\n}\n",
+			expected: false,
+		},
+		{
+			name:     "inputs",
+			input:    "inputs = {\n  region = \"us-east-1\"\n}\n",
+			expected: true,
+		},
+		{
+			name:     "include block",
+			input:    "include {\n  path = find_in_parent_folders()\n}\n",
+			expected: true,
+		},
+		{
+			name:     "terraform block",
+			input:    "terraform {\n  source = \"git::git@github.com:foo/bar.git\"\n}\n",
+			expected: true,
+		},
+		{
+			name:     "remote_state block",
+			input:    "remote_state {\n  backend = \"s3\"\n}\n",
+			expected: true,
+		},
+		{
+			name:     "remote_state attribute",
+			input:    "remote_state = {\n  backend = \"s3\"\n}\n",
+			expected: true,
+		},
+		{
+			name:     "include attribute",
+			input:    "include = \"x\"\n",
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := isUpgraded([]byte(c.input)); actual != c.expected {
+				t.Errorf("incorrect result: got=%t want=%t", actual, c.expected)
+			}
+		})
+	}
+
+	// a plain tfvars file is ignored as not being a terragrunt config,
+	// rather than skipped as already upgraded
+	dir := writeTree(t, map[string]string{"terraform.tfvars": "region = \"us-east-1\"\n"})
+	cmd := command{quiet: true}
+	entry, err := cmd.upgradeFile(filepath.Join(dir, "terraform.tfvars"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entry.Status != statusSkipped || entry.Error != errNotTerragruntConfig.Error() {
+		t.Errorf("incorrect report entry: %+v", entry)
+	}
+}
+
+func TestNestedBlockRules(t *testing.T) {
+	blockRules["terraform.extra_arguments"] = []string{"env"}
+	defer delete(blockRules, "terraform.extra_arguments")
+
+	input := `
+terragrunt = {
+  terraform {
+    extra_arguments "args" {
+      commands = ["plan"]
+
+      env {
+        TF_LOG = "debug"
+      }
+    }
+  }
+}
+
+env = {
+  name = "prod"
+}
+`
+	expected := `
+terraform {
+  extra_arguments "args" {
+    commands = ["plan"]
+
+    env {
+      TF_LOG = "debug"
+    }
+  }
+}
+
+inputs = {
+  env = {
+    name = "prod"
+  }
+}
+`
+
+	cmd := command{}
+	actual, err := cmd.upgrade([]byte(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(actual) != strings.TrimLeft(expected, "\n") {
+		t.Errorf("incorrect result (-want, +got):\n%s\n", diff.Diff(strings.TrimLeft(expected, "\n"), string(actual)))
+	}
+}
+
+func TestUpgradeInvalidOutput(t *testing.T) {
+	// quoted attribute names are not allowed outside of object constructors in hcl v2
+	input := `
+terragrunt = {
+  "iam role" = "terragrunt-iam-role"
+}
+`
+
+	cmd := command{}
+	actual, err := cmd.upgrade([]byte(input))
+	if !errors.Is(err, errInvalidOutput) {
+		t.Fatalf("incorrect error: got=%v want=%v", err, errInvalidOutput)
+	}
+	if actual != nil {
+		t.Errorf("unexpected output for invalid config:\n%s", actual)
+	}
+
+	// every diagnostic is rendered with the offending source
+	var ioe *invalidOutputError
+	if !errors.As(err, &ioe) {
+		t.Fatalf("incorrect error type: %T", err)
+	}
+	var buf bytes.Buffer
+	if err := ioe.writeDiagnostics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"Error: ", "terragrunt.hcl line 1", `"iam role" = "terragrunt-iam-role"`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("diagnostics do not contain %q:\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestUpgradeDottedKeysWithoutInputsBlock(t *testing.T) {
+	input := `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+tags.Name = "x"
+`
+
+	var err error
+	_, stderr := captureOutput(t, func() {
+		cmd := command{noInputsBlock: true, file: "terraform.tfvars"}
+		_, err = cmd.upgrade([]byte(input))
+	})
+	if !errors.Is(err, errInvalidOutput) {
+		t.Fatalf("incorrect error: got=%v want=%v", err, errInvalidOutput)
+	}
+
+	if expected := "warning: terraform.tfvars:8: input variable tags.Name is not a valid hcl v2 identifier"; !strings.Contains(stderr, expected) {
+		t.Errorf("expected warning %q, got:\n%s", expected, stderr)
+	}
+}
+
+func TestUpgradeQuotedKeys(t *testing.T) {
+	input := []byte(`
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+tags = {
+  "with space" = "a"
+  "quote\"d" = "b"
+  "50%{off}" = "c"
+}
+`)
+
+	var cmd command
+	output, err := cmd.upgrade(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, diags := hclv2parse.NewParser().ParseHCL(output, "terragrunt.hcl")
+	if diags.HasErrors() {
+		t.Fatalf("upgraded config is invalid: %v", diags)
+	}
+	inputs := f.Body.(*hclv2syntax.Body).Attributes["inputs"].Expr.(*hclv2syntax.ObjectConsExpr)
+	tags := inputs.Items[0].ValueExpr.(*hclv2syntax.ObjectConsExpr)
+
+	expected := []string{"with space", `quote"d`, "50%{off}"}
+	var actual []string
+	for _, item := range tags.Items {
+		key := item.KeyExpr.(*hclv2syntax.ObjectConsKeyExpr)
+		if _, ok := key.Wrapped.(*hclv2syntax.TemplateExpr); !ok {
+			t.Errorf("key is not a quoted string: %T", key.Wrapped)
+			continue
+		}
+		v, diags := key.Value(nil)
+		if diags.HasErrors() {
+			t.Fatalf("unexpected error: %v", diags)
+		}
+		actual = append(actual, v.AsString())
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("incorrect keys: got=%q want=%q", actual, expected)
+	}
+}
+
+func TestUpgradeNumber(t *testing.T) {
+	cases := []struct {
+		input    string
+		typ      hclv1token.Type
+		expected string
+		valid    bool
+	}{
+		{input: "10", typ: hclv1token.NUMBER, expected: "10", valid: true},
+		{input: "-10", typ: hclv1token.NUMBER, expected: "-10", valid: true},
+		{input: "0x10", typ: hclv1token.NUMBER, expected: "16", valid: true},
+		{input: "010", typ: hclv1token.NUMBER, expected: "8", valid: true},
+		{input: "1.5", typ: hclv1token.FLOAT, expected: "1.5", valid: true},
+		{input: "1E+6", typ: hclv1token.FLOAT, expected: "1E+6", valid: true},
+		{input: "08", typ: hclv1token.NUMBER, valid: false},
+		{input: "99999999999999999999", typ: hclv1token.NUMBER, valid: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			actual, err := upgradeNumber(hclv1token.Token{Type: c.typ, Text: c.input})
+			if c.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if !c.valid && err == nil {
+				t.Fatalf("expected an error for %s", c.input)
+			}
+
+			if actual != c.expected {
+				t.Errorf("incorrect number: got=%s want=%s", actual, c.expected)
+			}
+		})
+	}
+}
+
+func TestDestPath(t *testing.T) {
+	cases := []struct {
+		name     string
+		cmd      command
+		path     string
+		expected string
+		action   string
+	}{
+		{
+			name:     "default",
+			path:     "dir/terraform.tfvars",
+			expected: "dir/terragrunt.hcl",
+			action:   " (removing original)",
+		},
+		{
+			name:     "keep",
+			cmd:      command{keepOld: true},
+			path:     "dir/terraform.tfvars",
+			expected: "dir/terragrunt.hcl",
+			action:   " (keeping original)",
+		},
+		{
+			name:     "git mv",
+			cmd:      command{gitMv: true},
+			path:     "dir/terraform.tfvars",
+			expected: "dir/terragrunt.hcl",
+			action:   " (git mv)",
+		},
+		{
+			name:     "in place",
+			cmd:      command{inPlace: true},
+			path:     "dir/terraform.tfvars",
+			expected: "dir/terraform.tfvars",
+			action:   "",
+		},
+		{
+			name:     "compressed",
+			path:     "dir/terraform.tfvars.gz",
+			expected: "dir/terragrunt.hcl",
+			action:   " (removing original)",
+		},
+		{
+			name:     "compressed in place",
+			cmd:      command{inPlace: true, keepOld: true},
+			path:     "dir/terraform.tfvars.gz",
+			expected: "dir/terraform.tfvars",
+			action:   " (keeping original)",
+		},
+		{
+			name:     "preview",
+			cmd:      command{previewDir: "preview", gitMv: true},
+			path:     "dir/terraform.tfvars",
+			expected: "preview/dir/terragrunt.hcl",
+			action:   "",
+		},
+		{
+			name:     "relative to a parent directory",
+			path:     "../live/prod/app/terraform.tfvars",
+			expected: "../live/prod/app/terragrunt.hcl",
+			action:   " (removing original)",
+		},
+		{
+			name:     "already named terragrunt.hcl",
+			path:     "dir/terragrunt.hcl",
+			expected: "dir/terragrunt.hcl",
+			action:   "",
+		},
+		{
+			name:     "stdin",
+			path:     "-",
+			expected: "-",
+			action:   "",
+		},
+		{
+			name:     "stdout",
+			cmd:      command{stdout: true, keepOld: true},
+			path:     "dir/terraform.tfvars",
+			expected: "-",
+			action:   "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.FromSlash(c.path)
+			expected := filepath.FromSlash(c.expected)
+			if actual := c.cmd.destPath(path); actual != expected {
+				t.Errorf("incorrect destination: got=%s want=%s", actual, expected)
+			}
+			if actual := c.cmd.saveAction(path); actual != c.action {
+				t.Errorf("incorrect action: got=%q want=%q", actual, c.action)
+			}
+		})
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	cases := []struct {
+		name     string
+		exclude  []string
+		path     string
+		expected bool
+	}{
+		{name: "default", path: "live/.terragrunt-cache", expected: true},
+		{name: "default nested", path: "live/app/.terraform", expected: true},
+		{name: "not excluded", path: "live/app", expected: false},
+		{name: "name", exclude: []string{"vendor"}, path: "live/vendor", expected: true},
+		{name: "name only matches whole directories", exclude: []string{"vendor"}, path: "live/myvendor", expected: false},
+		{name: "path", exclude: []string{"live/app"}, path: "repo/live/app", expected: true},
+		{name: "path with trailing separator", exclude: []string{"live/app/"}, path: "live/app", expected: true},
+		{name: "path relative to the current directory", exclude: []string{"./live/app"}, path: "live/app", expected: true},
+		{name: "path only matches whole directories", exclude: []string{"live/app"}, path: "live/app/sub", expected: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var cmd command
+			for _, e := range c.exclude {
+				if err := cmd.excludeDirs.Set(filepath.FromSlash(e)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if actual := cmd.excluded(filepath.FromSlash(c.path)); actual != c.expected {
+				t.Errorf("incorrect result for %s: got=%t want=%t", c.path, actual, c.expected)
+			}
+		})
+	}
+}
+
+func TestWalkDepth(t *testing.T) {
+	cases := []struct {
+		root     string
+		path     string
+		expected int
+	}{
+		{root: "live", path: "live", expected: 0},
+		{root: "live", path: "live/app", expected: 1},
+		{root: "live", path: "live/prod/app", expected: 2},
+		{root: "live/", path: "live/prod/app/", expected: 2},
+		{root: ".", path: "live/prod", expected: 2},
+	}
+
+	for _, c := range cases {
+		root, path := filepath.FromSlash(c.root), filepath.FromSlash(c.path)
+		if actual := walkDepth(root, path); actual != c.expected {
+			t.Errorf("incorrect depth of %s under %s: got=%d want=%d", path, root, actual, c.expected)
+		}
+	}
+}
+
+func TestRenderRules(t *testing.T) {
+	cases := []struct {
+		version  string
+		expected renderRules
+		valid    bool
+	}{
+		{version: "", expected: renderRules{}, valid: true},
+		{version: "0.19", expected: renderRules{}, valid: true},
+		{version: "v0.22.9", expected: renderRules{}, valid: true},
+		{version: "0.23.0", expected: renderRules{remoteStateAttr: true}, valid: true},
+		{version: "1.0.0", expected: renderRules{remoteStateAttr: true}, valid: true},
+		{version: "0.18.7", valid: false},
+		{version: "latest", valid: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.version, func(t *testing.T) {
+			cmd := command{targetVersion: c.version}
+			actual, err := cmd.renderRules()
+			if c.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if !c.valid && err == nil {
+				t.Fatalf("expected an error for version %q", c.version)
+			}
+
+			if actual != c.expected {
+				t.Errorf("incorrect rules: got=%+v want=%+v", actual, c.expected)
+			}
+		})
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"mod1/terraform.tfvars":                        "",
+		"mod1/sub/terraform.tfvars":                    "",
+		"mod1/sub/sub/terraform.tfvars":                "",
+		"mod2/terraform.tfvars":                        "",
+		"mod2/.terragrunt-cache/abc/terraform.tfvars":  "",
+		"mod2/.terraform/modules/abc/terraform.tfvars": "",
+		"mod2/vendor/terraform.tfvars":                 "",
+		"mod3/other.tfvars":                            "",
+		"mod1/resources.tf":                            `variable "region" {}`,
+		"mod1/sub/legacy.tf":                           `terragrunt = { terraform { source = "../modules//app" } }`,
+		"mod3/main.tf":                                 `terragrunt = { include { path = "${find_in_parent_folders()}" } }`,
+		"mod3/hcl2.tf":                                 `locals { ids = [for s in var.subnets : s.id] }`,
+	})
+
+	cases := []struct {
+		name     string
+		cmd      command
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "directory without recursion",
+			args:     []string{"mod1"},
+			expected: []string{"mod1/terraform.tfvars"},
+		},
+		{
+			name:     "directory without a config",
+			args:     []string{"mod3"},
+			expected: nil,
+		},
+		{
+			name: "explicit files",
+			args: []string{"mod3/other.tfvars", "mod1/terraform.tfvars"},
+			expected: []string{
+				"mod3/other.tfvars",
+				"mod1/terraform.tfvars",
+			},
+		},
+		{
+			name: "explicit files and directories",
+			args: []string{"mod3/other.tfvars", "mod1", "mod2/.terragrunt-cache/abc/terraform.tfvars"},
+			expected: []string{
+				"mod3/other.tfvars",
+				"mod1/terraform.tfvars",
+				"mod2/.terragrunt-cache/abc/terraform.tfvars",
+			},
+		},
+		{
+			name: "explicit files and recursive directories",
+			cmd:  command{recursive: true},
+			args: []string{"mod1/sub/terraform.tfvars", "mod3/other.tfvars", "mod1"},
+			expected: []string{
+				"mod1/sub/terraform.tfvars",
+				"mod3/other.tfvars",
+				"mod1/sub/sub/terraform.tfvars",
+				"mod1/terraform.tfvars",
+			},
+		},
+		{
+			name: "recursive",
+			cmd:  command{recursive: true},
+			args: []string{"mod1", "mod2"},
+			expected: []string{
+				"mod1/sub/sub/terraform.tfvars",
+				"mod1/sub/terraform.tfvars",
+				"mod1/terraform.tfvars",
+				"mod2/terraform.tfvars",
+				"mod2/vendor/terraform.tfvars",
+			},
+		},
+		{
+			name: "recursive with excluded directories",
+			cmd:  command{recursive: true, excludeDirs: stringList{values: []string{".terragrunt-cache", "vendor", "mod1/sub"}}},
+			args: []string{"mod1", "mod2"},
+			expected: []string{
+				"mod1/terraform.tfvars",
+				"mod2/.terraform/modules/abc/terraform.tfvars",
+				"mod2/terraform.tfvars",
+			},
+		},
+		{
+			name: "recursive with max depth",
+			cmd:  command{recursive: true, maxDepth: 1},
+			args: []string{"", "mod1"},
+			expected: []string{
+				"mod1/terraform.tfvars",
+				"mod2/terraform.tfvars",
+				"mod1/sub/terraform.tfvars",
+			},
+		},
+		{
+			name:     "tf files without recursion",
+			cmd:      command{tfFiles: true},
+			args:     []string{"mod1", "mod3"},
+			expected: []string{"mod1/terraform.tfvars", "mod3/main.tf"},
+		},
+		{
+			name: "recursive with tf files",
+			cmd:  command{recursive: true, tfFiles: true},
+			args: []string{"mod1", "mod3"},
+			expected: []string{
+				"mod1/sub/legacy.tf",
+				"mod1/sub/sub/terraform.tfvars",
+				"mod1/sub/terraform.tfvars",
+				"mod1/terraform.tfvars",
+				"mod3/main.tf",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var args, expected []string
+			for _, a := range c.args {
+				args = append(args, filepath.Join(dir, filepath.FromSlash(a)))
+			}
+			for _, e := range c.expected {
+				expected = append(expected, filepath.Join(dir, filepath.FromSlash(e)))
+			}
+
+			c.cmd.quiet = true
+			actual, err := c.cmd.loadFiles(args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+				t.Errorf("incorrect files (-want, +got):\n%s\n", diff.Diff(strings.Join(expected, "\n"), strings.Join(actual, "\n")))
+			}
+		})
+	}
+}
+
+func TestResolveIncludes(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"live/terraform.tfvars":            `terragrunt = { remote_state { backend = "s3" } }`,
+		"live/prod/account.tfvars":         `terragrunt = { iam_role = "arn:aws:iam::123456789012:role/terragrunt" }`,
+		"live/prod/app/terraform.tfvars":   `terragrunt = { include { path = "${find_in_parent_folders()}" } }`,
+		"live/prod/db/terraform.tfvars":    `terragrunt = { include { path = "../../terraform.tfvars" } }`,
+		"live/prod/cache/terraform.tfvars": `terragrunt = { include { path = "${find_in_parent_folders("account.tfvars", "")}" } }`,
+		"live/prod/queue/terraform.tfvars": `terragrunt = { include { path = "${get_parent_tfvars_dir()}/terraform.tfvars" } }`,
+		"live/prod/web/terraform.tfvars":   `terragrunt = { include { path = "${find_in_parent_folders("missing.tfvars")}" } }`,
+	})
+
+	cases := []struct {
+		name     string
+		args     []string
+		expected []string
+		warnings int
+	}{
+		{
+			name:     "find_in_parent_folders",
+			args:     []string{"live/prod/app/terraform.tfvars"},
+			expected: []string{"live/prod/app/terraform.tfvars", "live/terraform.tfvars"},
+		},
+		{
+			name:     "relative path",
+			args:     []string{"live/prod/db/terraform.tfvars"},
+			expected: []string{"live/prod/db/terraform.tfvars", "live/terraform.tfvars"},
+		},
+		{
+			name:     "find_in_parent_folders with a file name",
+			args:     []string{"live/prod/cache/terraform.tfvars"},
+			expected: []string{"live/prod/cache/terraform.tfvars", "live/prod/account.tfvars"},
+		},
+		{
+			name:     "unresolved interpolation",
+			args:     []string{"live/prod/queue/terraform.tfvars"},
+			expected: []string{"live/prod/queue/terraform.tfvars"},
+			warnings: 1,
+		},
+		{
+			name:     "missing parent",
+			args:     []string{"live/prod/web/terraform.tfvars"},
+			expected: []string{"live/prod/web/terraform.tfvars"},
+			warnings: 1,
+		},
+		{
+			name: "each parent is added once, after the configs given",
+			args: []string{"live/prod/app/terraform.tfvars", "live/prod/db/terraform.tfvars", "live/prod/cache/terraform.tfvars"},
+			expected: []string{
+				"live/prod/app/terraform.tfvars",
+				"live/prod/db/terraform.tfvars",
+				"live/prod/cache/terraform.tfvars",
+				"live/terraform.tfvars",
+				"live/prod/account.tfvars",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var args, expected []string
+			for _, a := range c.args {
+				args = append(args, filepath.Join(dir, filepath.FromSlash(a)))
+			}
+			for _, e := range c.expected {
+				expected = append(expected, filepath.Join(dir, filepath.FromSlash(e)))
+			}
+
+			cmd := command{resolveIncludes: true, quiet: true}
+			actual, err := cmd.loadFiles(args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+				t.Errorf("incorrect files (-want, +got):\n%s\n", diff.Diff(strings.Join(expected, "\n"), strings.Join(actual, "\n")))
+			}
+			if cmd.warnings != c.warnings {
+				t.Errorf("incorrect number of warnings: got=%d want=%d", cmd.warnings, c.warnings)
+			}
+		})
+	}
+}
+
+func TestIncludeExcludeFrom(t *testing.T) {
+	include := `terragrunt = { include { path = "${find_in_parent_folders()}" } }`
+	dir := writeTree(t, map[string]string{
+		"live/terraform.tfvars":           `terragrunt = { remote_state { backend = "s3" } }`,
+		"live/prod/app/terraform.tfvars":  include,
+		"live/prod/db/terraform.tfvars":   include,
+		"live/stage/app/terraform.tfvars": include,
+		"live/stage/db/terraform.tfvars":  include,
+		"include.txt":                     "# modules to migrate\nlive/prod/*\n\n",
+		"include-stage.txt":               "live/stage/\n",
+		"include-app.txt":                 "./live/prod/app\n",
+		"exclude.txt":                     "*/*/db\n",
+		"invalid.txt":                     "live/[prod\n",
+	})
+	// patterns are matched against paths relative to the current directory
+	chdir(t, dir)
+
+	cases := []struct {
+		name     string
+		cmd      command
+		expected []string
+		valid    bool
+	}{
+		{
+			name:     "include",
+			cmd:      command{includeFrom: "include.txt"},
+			expected: []string{"live/prod/app/terraform.tfvars", "live/prod/db/terraform.tfvars"},
+			valid:    true,
+		},
+		{
+			name: "exclude",
+			cmd:  command{excludeFrom: "exclude.txt"},
+			expected: []string{
+				"live/prod/app/terraform.tfvars",
+				"live/stage/app/terraform.tfvars",
+				"live/terraform.tfvars",
+			},
+			valid: true,
+		},
+		{
+			name:     "include and exclude",
+			cmd:      command{includeFrom: "include-stage.txt", excludeFrom: "exclude.txt"},
+			expected: []string{"live/stage/app/terraform.tfvars"},
+			valid:    true,
+		},
+		{
+			name:     "include with includes",
+			cmd:      command{includeFrom: "include-app.txt", resolveIncludes: true},
+			expected: []string{"live/prod/app/terraform.tfvars", "live/terraform.tfvars"},
+			valid:    true,
+		},
+		{
+			name:  "invalid pattern",
+			cmd:   command{includeFrom: "invalid.txt"},
+			valid: false,
+		},
+		{
+			name:  "missing file",
+			cmd:   command{excludeFrom: "missing.txt"},
+			valid: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var expected []string
+			for _, e := range c.expected {
+				expected = append(expected, filepath.FromSlash(e))
+			}
+
+			c.cmd.recursive = true
+			c.cmd.quiet = true
+			actual, err := c.cmd.loadFiles([]string{"live"})
+			if c.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if !c.valid {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+
+			if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+				t.Errorf("incorrect files (-want, +got):\n%s\n", diff.Diff(strings.Join(expected, "\n"), strings.Join(actual, "\n")))
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"upgraded/terraform.tfvars": `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+
+  terraform {
+    extra_arguments "args" {
+      required_var_files = ["${get_tfvars_dir()}/common.tfvars"]
+    }
+  }
+}
+
+path = "${get_tfvars_dir()}/foo"
+`,
+		"skipped/terraform.tfvars": `foo = { bar { baz = 1 } }`,
+		"upgraded2/terragrunt.hcl": `include {
+  path = find_in_parent_folders()
+}
+`,
+		"invalid/terraform.tfvars": `terragrunt = {`,
+	})
+
+	reportPath := filepath.Join(dir, "report.json")
+	manifestPath := filepath.Join(dir, "manifest.csv")
+	// the temporary directory is not in a git repository
+	cmd := command{recursive: true, allowUnbounded: true, quiet: true, reportPath: reportPath, manifestPath: manifestPath}
+	if _, _, err := captureRun(t, &cmd, dir); err == nil {
+		t.Fatalf("expected an error upgrading an invalid config")
+	}
+
+	b, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report []reportEntry
+	if err := json.Unmarshal(b, &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+
+	// files are walked in lexical order, and processing stops at the first error
+	if len(report) != 1 {
+		t.Fatalf("incorrect number of report entries: got=%d want=1", len(report))
+	}
+	if e := report[0]; e.Status != statusError || e.Error == "" {
+		t.Errorf("incorrect report entry for invalid config: %+v", e)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "invalid")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := captureRun(t, &cmd, dir, filepath.Join(dir, "upgraded2", "terragrunt.hcl")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err = ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = nil
+	if err := json.Unmarshal(b, &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+
+	expected := []reportEntry{
+		{
+			Source: filepath.Join(dir, "skipped", "terraform.tfvars"),
+			Status: statusSkipped,
+			Error:  errNotTerragruntConfig.Error(),
+		},
+		{
+			Source:         filepath.Join(dir, "upgraded", "terraform.tfvars"),
+			Destination:    filepath.Join(dir, "upgraded", "terragrunt.hcl"),
+			Status:         statusUpgraded,
+			Renames:        2,
+			Interpolations: 1,
+		},
+		{
+			Source: filepath.Join(dir, "upgraded2", "terragrunt.hcl"),
+			Status: statusSkipped,
+			Error:  errAlreadyUpgraded.Error(),
+		},
+	}
+	if len(report) != len(expected) {
+		t.Fatalf("incorrect number of report entries: got=%d want=%d", len(report), len(expected))
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(report[i], expected[i]) {
+			t.Errorf("incorrect report entry: got=%+v want=%+v", report[i], expected[i])
+		}
+	}
+
+	// the manifest only lists the files that were upgraded
+	b, err = ioutil.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedManifest := fmt.Sprintf("source,destination\n%s,%s\n", filepath.Join(dir, "upgraded", "terraform.tfvars"), filepath.Join(dir, "upgraded", "terragrunt.hcl"))
+	if diff := diff.Diff(string(b), expectedManifest); diff != "" {
+		t.Errorf("incorrect manifest:\n%s", diff)
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	dir := writeTree(t, map[string]string{"terraform.tfvars": "terragrunt = {}\n"})
+	path := filepath.Join(dir, "terraform.tfvars")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cmd := command{quiet: true}
+	if err := cmd.run(ctx, []string{path}); err == nil {
+		t.Fatalf("expected an error when interrupted")
+	}
+
+	// no files should be touched once interrupted
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("source file was modified: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "terragrunt.hcl")); !os.IsNotExist(err) {
+		t.Errorf("unexpected terragrunt.hcl written: %v", err)
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir := writeTree(t, map[string]string{"terraform.tfvars": "terragrunt = {}\n"})
+	path := filepath.Join(dir, "terraform.tfvars")
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := command{quiet: true}
+	contents := []byte("include {}\n")
+	if err := cmd.save(path, contents); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newPath := filepath.Join(dir, "terragrunt.hcl")
+	b, err := ioutil.ReadFile(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(contents) {
+		t.Errorf("incorrect contents: got=%q want=%q", b, contents)
+	}
+
+	fi, err := os.Stat(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("permissions not preserved: got=%v want=%v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// only the upgraded config should be left, without any temporary files
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "terragrunt.hcl" {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("unexpected files after save: %v", names)
+	}
+}
+
+func TestNestedTerragruntKeys(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []int
+		warnings string
+	}{
+		{
+			name: "top level only",
+			input: `
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+foo = "bar"
+`,
+			expected: nil,
+		},
+		{
+			name: "nested",
+			input: `
+terragrunt = {
+  terraform {
+    terragrunt = {}
+  }
+}
+
+foo = {
+  "terragrunt" = {
+    source = "git::git@github.com:foo/bar.git"
+  }
+}
+
+bar "terragrunt" {}
+`,
+			expected: []int{3, 8, 13},
+			warnings: "warning: line 3: found a terragrunt key below the top level of the config. it will be upgraded as a regular value, which is probably not correct\n" +
+				"warning: line 8: found a terragrunt key below the top level of the config. it will be upgraded as a regular value, which is probably not correct\n" +
+				"warning: line 13: found a terragrunt key below the top level of the config. it will be upgraded as a regular value, which is probably not correct\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := hclv1parser.Parse([]byte(strings.TrimLeft(c.input, "\n")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var lines []int
+			for _, pos := range nestedTerragruntKeys(res.Node.(*hclv1ast.ObjectList)) {
+				lines = append(lines, pos.Line)
+			}
+			if fmt.Sprint(lines) != fmt.Sprint(c.expected) {
+				t.Errorf("incorrect lines: got=%v want=%v", lines, c.expected)
+			}
+
+			cmd := command{logLevel: "warn"}
+			_, stderr := captureOutput(t, func() {
+				if _, err := cmd.upgrade([]byte(strings.TrimLeft(c.input, "\n"))); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+			if stderr != c.warnings {
+				t.Errorf("incorrect warnings (-want, +got):\n%s\n", diff.Diff(c.warnings, stderr))
+			}
+		})
+	}
+}
+
+func TestUpgradeMigrationNote(t *testing.T) {
+	input := []byte(`
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`)
+
+	var cmd command
+	expected, err := cmd.upgrade(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cmd.addMigrationNote = true
+	actual, err := cmd.upgrade(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.SplitN(string(actual), "\n", 3)
+	if !strings.HasPrefix(lines[0], "# Upgraded by "+name+" ") {
+		t.Errorf("missing migration note: %q", lines[0])
+	}
+	if lines[1] != "" {
+		t.Errorf("expected a blank line after the migration note: %q", lines[1])
+	}
+	if diff := diff.Diff(lines[2], string(expected)); diff != "" {
+		t.Errorf("incorrect output after migration note:\n%s", diff)
+	}
+}
+
+func TestUpgradeLeadingWhitespace(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "comment first",
+			input: `# about this module
+
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`,
+			expected: `# about this module
+
+include {
+  path = find_in_parent_folders()
+}
+`,
+		},
+		{
+			name: "block comment first",
+			input: `/* about this module */
+
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`,
+			expected: `/* about this module */
+
+include {
+  path = find_in_parent_folders()
+}
+`,
+		},
+		{
+			name: "terragrunt attribute first",
+			input: `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`,
+			expected: `include {
+  path = find_in_parent_folders()
+}
+`,
+		},
+	}
+
+	// the upgraded config is the same however many blank lines, or lines
+	// of whitespace, the original started with
+	for _, c := range cases {
+		for _, prefix := range []string{"", "\n", "\n\n\n", "  \n\t\n"} {
+			t.Run(fmt.Sprintf("%s/%q", c.name, prefix), func(t *testing.T) {
+				var cmd command
+				actual, err := cmd.upgrade([]byte(prefix + c.input))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(actual) != c.expected {
+					t.Errorf("incorrect result (-want, +got):\n%s\n", diff.Diff(c.expected, string(actual)))
+				}
+
+				// and exactly one blank line follows the migration note
+				cmd.addMigrationNote = true
+				actual, err = cmd.upgrade([]byte(prefix + c.input))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				lines := strings.SplitN(string(actual), "\n", 3)
+				if lines[1] != "" || lines[2] != c.expected {
+					t.Errorf("incorrect result with migration note:\n%s", actual)
+				}
+			})
+		}
+	}
+}
+
+func TestDumpAST(t *testing.T) {
+	input := `
+terragrunt = {
+  # lead comment
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+# detached comment
+
+foo = ["bar"] # line comment
+`
+	expected := `
+terragrunt settings:
+  3:3 ObjectItem include
+    lead comment:
+      2:3 # lead comment
+    3:11 ObjectType
+      4:5 ObjectItem path
+        4:12 LiteralType STRING "${find_in_parent_folders()}"
+inputs:
+  10:1 ObjectItem foo
+    line comment:
+      10:15 # line comment
+    10:7 ListType
+      10:8 LiteralType STRING "bar"
+detached comments:
+  8:1 # detached comment
+`
+
+	res, err := hclv1parser.Parse([]byte(strings.TrimLeft(input, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cmd command
+	root := res.Node.(*hclv1ast.ObjectList)
+	tg := root.Items[0].Val.(*hclv1ast.ObjectType)
+
+	var buf bytes.Buffer
+	dumpAST(&buf, tg.List.Items, root.Items[1:], cmd.loadDetachedComments(res))
+	if diff := diff.Diff(buf.String(), strings.TrimLeft(expected, "\n")); diff != "" {
+		t.Errorf("incorrect output:\n%s", diff)
+	}
+}
+
+func TestUpgradeWindowsInput(t *testing.T) {
+	input := `terragrunt = {
+  # lead comment
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+/*
+ * multi-line comment
+ */
+foo = "bar" // line comment
+heredoc = <<EOF
+line 1
+line 2
+EOF
+`
+
+	var cmd command
+	expected, err := cmd.upgrade([]byte(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	crlf := strings.Replace(input, "\n", "\r\n", -1)
+	cases := []struct {
+		name  string
+		input string
+	}{
+		{name: "crlf", input: crlf},
+		{name: "bom", input: "\xef\xbb\xbf" + input},
+		{name: "bom and crlf", input: "\xef\xbb\xbf" + crlf},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := cmd.upgrade([]byte(c.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := diff.Diff(string(actual), string(expected)); diff != "" {
+				t.Errorf("incorrect output:\n%s", diff)
+			}
+			if bytes.ContainsRune(actual, '\r') {
+				t.Errorf("output contains a carriage return: %q", actual)
+			}
+		})
+	}
+}
+
+func TestCheckTerragrunt(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name: "valid",
+			input: `
+include {
+  path = find_in_parent_folders()
+}
+
+remote_state {
+  backend = "s3"
+}
+
+terraform {
+  source = "git::git@github.com:foo/bar.git"
+
+  extra_arguments "args" {
+    commands = ["plan"]
+  }
+
+  before_hook "hook" {
+    commands = ["apply"]
+    execute  = ["echo", "hi"]
+  }
+}
+
+dependencies {
+  paths = ["../vpc"]
+}
+`,
+			expected: nil,
+		},
+		{
+			name: "missing attributes",
+			input: `
+include {
+}
+
+remote_state {
+  config = {}
+}
+
+terraform {
+  after_hook "hook" {
+    commands = ["apply"]
+  }
+}
+`,
+			expected: []string{
+				"line 1: include block is missing path",
+				"line 4: remote_state block is missing backend",
+				"line 9: after_hook block is missing execute",
+			},
+		},
+		{
+			name: "labels",
+			input: `
+include "root" {
+  path = find_in_parent_folders()
+}
+
+terraform {
+  extra_arguments {
+    commands = ["plan"]
+  }
+}
+`,
+			expected: []string{
+				"line 1: include block must have 0 label(s), but has 1",
+				"line 6: extra_arguments block must have 1 label(s), but has 0",
+			},
+		},
+		{
+			name: "dependency",
+			input: `
+dependency {
+  config_path = "../vpc"
+}
+
+dependency "mysql" {
+  mock_outputs = {}
+}
+`,
+			expected: []string{
+				"line 1: dependency block must have 1 label(s), but has 0",
+				"line 5: dependency block is missing config_path",
+			},
+		},
+		{
+			name: "remote_state attribute",
+			input: `
+remote_state = {
+  "backend" = "s3"
+}
+`,
+			expected: nil,
+		},
+		{
+			name: "remote_state attribute without backend",
+			input: `
+remote_state = {
+  config = {}
+}
+`,
+			expected: []string{"line 1: remote_state is missing backend"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual := checkTerragrunt([]byte(strings.TrimLeft(c.input, "\n")))
+			if diff := diff.Diff(strings.Join(actual, "\n"), strings.Join(c.expected, "\n")); diff != "" {
+				t.Errorf("incorrect problems:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpgradeExpr(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "multiple arguments",
+			input:    `"${merge(var.a, var.b)}"`,
+			expected: `merge(var.a,var.b)`,
+		},
+		{
+			name:     "nested function calls",
+			input:    `"${concat(list(var.a), split(",", var.b))}"`,
+			expected: `concat(list(var.a),split(",",var.b))`,
+		},
+		{
+			name:     "nested interpolation",
+			input:    `"${merge(var.a, {x = "${var.y}"})}"`,
+			expected: `merge(var.a,{x="${var.y}"})`,
+		},
+		{
+			name:     "nested interpolations next to each other",
+			input:    `"${merge(var.a, {x = "${var.y}${var.z}"})}"`,
+			expected: `merge(var.a,{x="${var.y}${var.z}"})`,
+		},
+		{
+			name:     "text around the interpolation",
+			input:    `"a-${merge(var.a, var.b)}"`,
+			expected: `"a-${merge(var.a,var.b)}"`,
+		},
+		{
+			name:     "multiple interpolations",
+			input:    `"${merge(var.a, var.b)}${var.c}"`,
+			expected: `"${merge(var.a,var.b)}${var.c}"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var actual string
+			for _, t := range upgradeExpr(c.input) {
+				actual += string(t.Bytes)
+			}
+			if actual != c.expected {
+				t.Errorf("incorrect expression: got=%s want=%s", actual, c.expected)
+			}
+		})
+	}
+}
+
+func TestRemovedFunctions(t *testing.T) {
+	changes := funcChanges
+	defer func() { funcChanges = changes }()
+	funcChanges = []struct {
+		version  string
+		from, to string
+	}{
+		{version: "0.19.0", from: "get_tfvars_dir", to: "get_terragrunt_dir"},
+		{version: "0.19.0", from: "import_parent_tree", to: ""},
+	}
+
+	cases := []struct {
+		name    string
+		input   string
+		renamed []string
+		removed []string
+	}{
+		{
+			name:    "renamed functions",
+			input:   `"${get_env("HOME", find_in_parent_folders())}/${get_tfvars_dir()}"`,
+			renamed: []string{"get_tfvars_dir -> get_terragrunt_dir"},
+		},
+		{
+			name:    "removed functions",
+			input:   `"${import_parent_tree()}/${upper(import_parent_tree("HOME"))}"`,
+			removed: []string{"import_parent_tree", "import_parent_tree"},
+		},
+		{
+			name:  "not a function call",
+			input: `"${var.import_parent_tree}/import_parent_tree()"`,
+		},
+	}
+
+	fu, err := (&command{}).funcUpgrades()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var tok hclv2write.Tokens
+			for _, t := range upgradeExpr(c.input) {
+				tok = append(tok, &hclv2write.Token{Type: t.Type, Bytes: t.Bytes})
+			}
+
+			renamed, removed := upgradeFunctionNames(tok, fu)
+			if !reflect.DeepEqual(renamed, c.renamed) {
+				t.Errorf("incorrect renames: got=%v want=%v", renamed, c.renamed)
+			}
+			if !reflect.DeepEqual(removed, c.removed) {
+				t.Errorf("incorrect removed functions: got=%v want=%v", removed, c.removed)
+			}
+		})
+	}
+
+	// the warning names the file and the function
+	dir := writeTree(t, map[string]string{"terraform.tfvars": `terragrunt = {
+  terraform {
+    source = "${import_parent_tree()}"
+  }
+}
+`})
+	path := filepath.Join(dir, "terraform.tfvars")
+	cmd := command{inPlace: true, logLevel: "warn"}
+	_, stderr := captureOutput(t, func() {
+		_, err = cmd.upgradeFile(path)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "warning: " + path + ":3: function import_parent_tree was removed in terragrunt v0.19.0, so it must be replaced by hand\n"; stderr != expected {
+		t.Errorf("incorrect warnings (-want, +got):\n%s\n", diff.Diff(expected, stderr))
+	}
+}
+
+func TestFunctionRenames(t *testing.T) {
+	cases := []struct {
+		name     string
+		renames  []string
+		expected map[string]string
+		err      string
+	}{
+		{
+			name: "default",
+			expected: map[string]string{
+				"get_tfvars_dir":        "get_terragrunt_dir",
+				"get_parent_tfvars_dir": "get_parent_terragrunt_dir",
+			},
+		},
+		{
+			name:    "custom",
+			renames: []string{"get_account=get_aws_account_id", "get_tfvars_dir=get_parent_terragrunt_dir"},
+			expected: map[string]string{
+				"get_tfvars_dir":        "get_parent_terragrunt_dir",
+				"get_parent_tfvars_dir": "get_parent_terragrunt_dir",
+				"get_account":           "get_aws_account_id",
+			},
+		},
+		{
+			name:    "missing new name",
+			renames: []string{"get_account"},
+			err:     `invalid --rename-function "get_account". must be old=new, where both are function names`,
+		},
+		{
+			name:    "not a function name",
+			renames: []string{"get_account=get_aws_account_id()"},
+			err:     `invalid --rename-function "get_account=get_aws_account_id()". must be old=new, where both are function names`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := command{renameFunctions: stringList{values: c.renames}}
+			fu, err := cmd.funcUpgrades()
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("incorrect error: got=%v want=%s", err, c.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(fu.renames, c.expected) {
+				t.Errorf("incorrect renames: got=%v want=%v", fu.renames, c.expected)
+			}
+		})
+	}
+}
+
+func TestWalkBounds(t *testing.T) {
+	dir := writeTree(t, map[string]string{"repo/sub/terraform.tfvars": ""})
+	repo := filepath.Join(dir, "repo")
+	sub := filepath.Join(repo, "sub")
+	gitRepo(t, repo)
+
+	root, err := gitRoot(sub)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, err := within(repo, root); err != nil || !ok {
+		t.Errorf("incorrect git root: got=%s want=%s", root, repo)
+	}
+
+	cases := []struct {
+		path     string
+		expected bool
+	}{
+		{path: repo, expected: true},
+		{path: sub, expected: true},
+		{path: filepath.Join(sub, ".."), expected: true},
+		{path: dir, expected: false},
+		{path: filepath.Join(sub, "..", ".."), expected: false},
+	}
+	for _, c := range cases {
+		if actual, err := within(root, c.path); err != nil {
+			t.Errorf("unexpected error for %s: %v", c.path, err)
+		} else if actual != c.expected {
+			t.Errorf("incorrect result for %s: got=%t want=%t", c.path, actual, c.expected)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{
+		"REGION": "us-east-1",
+		"QUOTED": `a "b" \c`,
+		"INTERP": "${var.foo}",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		unset    []string
+	}{
+		{
+			name:     "shell variables",
+			input:    `"$REGION/${REGION}"`,
+			expected: `"us-east-1/us-east-1"`,
+		},
+		{
+			name:     "hcl interpolations",
+			input:    `"${var.REGION}/${get_env("REGION", "")}/${REGION}"`,
+			expected: `"${var.REGION}/${get_env("REGION", "")}/us-east-1"`,
+		},
+		{
+			name:     "escaped dollar signs",
+			input:    `"$$REGION/$${REGION}"`,
+			expected: `"$$REGION/$${REGION}"`,
+		},
+		{
+			name:     "values are escaped",
+			input:    `"$QUOTED $INTERP"`,
+			expected: `"a \"b\" \\c $${var.foo}"`,
+		},
+		{
+			name:     "unset variables",
+			input:    `"$UNSET/${ALSO_UNSET}/$REGION"`,
+			expected: `"$UNSET/${ALSO_UNSET}/us-east-1"`,
+			unset:    []string{"UNSET", "ALSO_UNSET"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, unset := expandEnv(c.input, lookup)
+			if actual != c.expected {
+				t.Errorf("incorrect expansion: got=%s want=%s", actual, c.expected)
+			}
+			if fmt.Sprint(unset) != fmt.Sprint(c.unset) {
+				t.Errorf("incorrect unset variables: got=%v want=%v", unset, c.unset)
+			}
+		})
+	}
+}
+
+func TestUpgradeStdinErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "empty",
+			input:    "",
+			expected: errEmptyStdin.Error(),
+		},
+		{
+			name:     "whitespace",
+			input:    "\n  \n",
+			expected: errEmptyStdin.Error(),
+		},
+		{
+			name:     "parse error",
+			input:    "terragrunt = {",
+			expected: "error upgrading stdin: error parsing file",
+		},
+		{
+			name:     "no terragrunt attribute",
+			input:    "foo = { bar { baz = 1 } }",
+			expected: "stdin does not contain a terragrunt attribute",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			withStdin(t, c.input)
+
+			cmd := command{quiet: true}
+			entry, err := cmd.upgradeFile("-")
+			if err == nil || !strings.HasPrefix(err.Error(), c.expected) {
+				t.Errorf("incorrect error: got=%v want=%s", err, c.expected)
+			}
+			if entry.Status != statusError {
+				t.Errorf("incorrect status: got=%s want=%s", entry.Status, statusError)
+			}
+		})
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	// invalid configs, which would fail if they were upgraded
+	files := map[string]string{
+		"mod1/terraform.tfvars": "terragrunt = {",
+		"mod2/terraform.tfvars": "terragrunt = {",
+	}
+	dir := writeTree(t, files)
+
+	cmd := command{recursive: true, allowUnbounded: true, listFiles: true}
+	stdout, _, err := captureRun(t, &cmd, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := filepath.Join(dir, "mod1", "terraform.tfvars") + "\n" + filepath.Join(dir, "mod2", "terraform.tfvars") + "\n"
+	if diff := diff.Diff(stdout, expected); diff != "" {
+		t.Errorf("incorrect files:\n%s", diff)
+	}
+
+	// nothing should have been upgraded
+	if actual := readTree(t, dir); !reflect.DeepEqual(actual, files) {
+		t.Errorf("files were modified: %v", actual)
+	}
+}
+
+func TestMergeFragments(t *testing.T) {
+	primary := []byte(`terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+foo = "bar"
+`)
+
+	tests := []struct {
+		name      string
+		fragments []fragment
+		expected  string
+		sources   []mergedSource
+		err       string
+	}{
+		{
+			name:     "no fragments",
+			expected: string(primary),
+		},
+		{
+			name: "fragments are appended in order",
+			fragments: []fragment{
+				{path: "a.tfvars", contents: []byte("a = 1\n")},
+				{path: "b.tfvars", contents: []byte("b = 2\n")},
+			},
+			expected: string(primary) + "\na = 1\n\nb = 2\n",
+			sources:  []mergedSource{{path: "a.tfvars", line: 9}, {path: "b.tfvars", line: 11}},
+		},
+		{
+			name: "variable set in the primary",
+			fragments: []fragment{
+				{path: "a.tfvars", contents: []byte("foo = \"baz\"\n")},
+			},
+			err: "cannot merge fragments: foo is set in both terraform.tfvars and a.tfvars",
+		},
+		{
+			name: "variable set in two fragments",
+			fragments: []fragment{
+				{path: "a.tfvars", contents: []byte("a = 1\n")},
+				{path: "b.tfvars", contents: []byte("a = 2\n")},
+			},
+			err: "cannot merge fragments: a is set in both a.tfvars and b.tfvars",
+		},
+		{
+			name: "terragrunt settings in a fragment",
+			fragments: []fragment{
+				{path: "a.tfvars", contents: []byte("terragrunt = {}\n")},
+			},
+			err: "cannot merge fragments: a.tfvars contains terragrunt settings, which may only be in terraform.tfvars",
+		},
+		{
+			name: "invalid fragment",
+			fragments: []fragment{
+				{path: "a.tfvars", contents: []byte("a = {")},
+			},
+			err: "error parsing a.tfvars",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, sources, err := mergeFragments("terraform.tfvars", primary, tt.fragments)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got: %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := diff.Diff(string(merged), tt.expected); diff != "" {
+				t.Errorf("incorrect output:\n%s", diff)
+			}
+			if !reflect.DeepEqual(sources, tt.sources) {
+				t.Errorf("incorrect sources: got=%+v want=%+v", sources, tt.sources)
+			}
+		})
+	}
+}
+
+func TestMergedLocations(t *testing.T) {
+	cmd := command{
+		file:    "terraform.tfvars",
+		sources: []mergedSource{{path: "a.tfvars", line: 9}, {path: "b.tfvars", line: 11}},
+	}
+
+	cases := []struct {
+		line    int
+		warning string
+		err     string
+	}{
+		{line: 8, warning: "terraform.tfvars:8: problem", err: "line 8: problem"},
+		{line: 9, warning: "a.tfvars:1: problem", err: "a.tfvars:1: problem"},
+		{line: 12, warning: "b.tfvars:2: problem", err: "b.tfvars:2: problem"},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.line), func(t *testing.T) {
+			_, stderr := captureOutput(t, func() {
+				cmd.configWarnf(c.line, "problem")
+			})
+			if expected := "warning: " + c.warning + "\n"; stderr != expected {
+				t.Errorf("incorrect warning: got=%q want=%q", stderr, expected)
+			}
+			if err := cmd.configErrorf(c.line, "problem"); err.Error() != c.err {
+				t.Errorf("incorrect error: got=%v want=%s", err, c.err)
+			}
+		})
+	}
+}
+
+func TestUpgradeMerge(t *testing.T) {
+	files := map[string]string{
+		"terraform.tfvars": `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+foo = "bar"
+`,
+		"common.tfvars": `baz = "${get_env("BAZ", "")}"
+qux = "$TGU_MERGE_UNSET"
+`,
+	}
+	dir := writeTree(t, files)
+
+	cmd := command{merge: true, inPlace: true, expandEnv: true, logLevel: "warn"}
+	loaded, err := cmd.loadFiles([]string{filepath.Join(dir, "terraform.tfvars"), filepath.Join(dir, "common.tfvars")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{filepath.Join(dir, "terraform.tfvars")}; !reflect.DeepEqual(loaded, expected) {
+		t.Fatalf("incorrect files: expected %v, got %v", expected, loaded)
+	}
+
+	var entry reportEntry
+	_, stderr := captureOutput(t, func() {
+		entry, err = cmd.upgradeFile(loaded[0])
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// warnings are reported in the fragment they came from
+	if expected := "warning: " + filepath.Join(dir, "common.tfvars") + ":2: environment variable TGU_MERGE_UNSET is not set. it will not be expanded\n"; stderr != expected {
+		t.Errorf("incorrect warnings (-want, +got):\n%s\n", diff.Diff(expected, stderr))
+	}
+	if expected := []string{filepath.Join(dir, "common.tfvars")}; !reflect.DeepEqual(entry.Merged, expected) {
+		t.Errorf("incorrect merged files: expected %v, got %v", expected, entry.Merged)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "terraform.tfvars"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `include {
+  path = find_in_parent_folders()
+}
+
+inputs = {
+  foo = "bar"
+  baz = get_env("BAZ", "")
+  qux = "$TGU_MERGE_UNSET"
+}
+`
+	if diff := diff.Diff(string(b), expected); diff != "" {
+		t.Errorf("incorrect output:\n%s", diff)
+	}
+
+	// the fragment is left alone
+	b, err = ioutil.ReadFile(filepath.Join(dir, "common.tfvars"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != files["common.tfvars"] {
+		t.Errorf("fragment was modified: %s", b)
+	}
+}
+
+func TestDiffOnlyChanged(t *testing.T) {
+	files := map[string]string{
+		"old/terraform.tfvars": `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`,
+		"new/terraform.tfvars": `include {
+  path = find_in_parent_folders()
+}
+`,
+	}
+	dir := writeTree(t, files)
+
+	cmd := command{recursive: true, allowUnbounded: true, diffOnlyChanged: true, quiet: true}
+	stdout, _, err := captureRun(t, &cmd, dir)
+	if err == nil || err.Error() != "1 file(s) would be upgraded" {
+		t.Errorf("expected an error, got: %v", err)
+	}
+
+	if expected := filepath.Join(dir, "old", "terraform.tfvars") + "\n"; stdout != expected {
+		t.Errorf("incorrect output: expected %q, got %q", expected, stdout)
+	}
+
+	// nothing should have been written
+	if actual := readTree(t, dir); !reflect.DeepEqual(actual, files) {
+		t.Errorf("files were modified: %v", actual)
+	}
+}
+
+func TestUpgradeJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name: "json config",
+			input: `{
+  "terragrunt": {
+    "include": {
+      "path": "${find_in_parent_folders()}"
+    }
+  },
+  "foo": "bar"
+}
+`,
+			err: errJSONInput.Error(),
+		},
+		{
+			name: "json-style object",
+			input: `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+
+foo = {
+  bar: "baz"
+}
+`,
+			err: `error parsing file: At 8:6: illegal char. use "key = value" instead of the JSON-style "key: value"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := command{}
+			_, err := cmd.upgrade([]byte(tt.input))
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("incorrect error: got=%v want=%v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestGitMv(t *testing.T) {
+	config := `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`
+
+	cases := []struct {
+		name     string
+		batchGit bool
+	}{
+		{name: "git mv"},
+		{name: "parallel safe", batchGit: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{
+				"mod1/terraform.tfvars": config,
+				"mod2/terraform.tfvars": config,
+				"mod3/terraform.tfvars": config,
+			})
+			git := gitRepo(t, dir)
+			// mod3 is not tracked, so it can't be moved
+			git("add", "mod1", "mod2")
+			git("commit", "-q", "-m", "initial")
+			chdir(t, dir)
+
+			cmd := command{recursive: true, gitMv: true, batchGit: c.batchGit, quiet: true}
+			_, _, err := captureRun(t, &cmd, ".")
+			if err == nil || !strings.Contains(err.Error(), "not under version control") {
+				t.Errorf("expected an error moving mod3, got: %v", err)
+			}
+
+			expected := "D\tmod1/terraform.tfvars\nA\tmod1/terragrunt.hcl\nD\tmod2/terraform.tfvars\nA\tmod2/terragrunt.hcl\n"
+			if diff := diff.Diff(git("diff", "--cached", "--name-status", "--no-renames"), expected); diff != "" {
+				t.Errorf("incorrect staged changes:\n%s", diff)
+			}
+
+			// the untracked file is left as it was, rather than updated
+			// without being moved
+			files := readTree(t, dir)
+			if actual := files["mod3/terraform.tfvars"]; actual != config {
+				t.Errorf("untracked file was modified: %s", actual)
+			}
+			if actual := files["mod1/terragrunt.hcl"]; actual != "include {\n  path = find_in_parent_folders()\n}\n" {
+				t.Errorf("incorrect upgraded config: %s", actual)
+			}
+		})
+	}
+}
+
+func TestSince(t *testing.T) {
+	include := `terragrunt = { include { path = "${find_in_parent_folders()}" } }`
+	dir := writeTree(t, map[string]string{
+		"live/mod1/terraform.tfvars": include,
+		"live/mod2/terraform.tfvars": include,
+		"live/mod3/terraform.tfvars": include,
+		"live/mod4/terraform.tfvars": include,
+		"live/terraform.tfvars":      `terragrunt = { remote_state { backend = "s3" } }`,
+	})
+	git := gitRepo(t, dir)
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	git("tag", "base")
+
+	// mod1 is changed in a commit, and mod2 isn't committed yet
+	write := func(f, contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(f)), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("live/mod1/terraform.tfvars", include+"\nregion = \"us-east-1\"\n")
+	git("commit", "-q", "-am", "mod1")
+	write("live/mod2/terraform.tfvars", include+"\nregion = \"us-west-2\"\n")
+
+	// paths are relative to a subdirectory, while git reports them relative
+	// to the root of the repository
+	chdir(t, filepath.Join(dir, "live"))
+
+	cases := []struct {
+		name     string
+		cmd      command
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "changed",
+			cmd:      command{since: "base"},
+			expected: []string{"mod1/terraform.tfvars", "mod2/terraform.tfvars"},
+		},
+		{
+			name:     "changed with includes",
+			cmd:      command{since: "base", resolveIncludes: true},
+			expected: []string{"mod1/terraform.tfvars", "mod2/terraform.tfvars", "terraform.tfvars"},
+		},
+		{
+			name:     "uncommitted changes",
+			cmd:      command{since: "HEAD"},
+			expected: []string{"mod2/terraform.tfvars"},
+		},
+		{name: "unknown ref", cmd: command{since: "no-such-ref"}, err: true},
+		{name: "option", cmd: command{since: "--output=x"}, err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var expected []string
+			for _, e := range c.expected {
+				expected = append(expected, filepath.FromSlash(e))
+			}
+
+			c.cmd.recursive = true
+			c.cmd.quiet = true
+			actual, err := c.cmd.loadFiles([]string{"."})
+			if c.err {
+				if err == nil {
+					t.Errorf("expected an error for --since=%s", c.cmd.since)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+				t.Errorf("incorrect files (-want, +got):\n%s\n", diff.Diff(strings.Join(expected, "\n"), strings.Join(actual, "\n")))
+			}
+		})
+	}
+}
+
+func TestUpgradeGzip(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte("terragrunt = {\r\n  include {\r\n    path = \"${find_in_parent_folders()}\"\r\n  }\r\n}\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir := writeTree(t, map[string]string{"terraform.tfvars.gz": buf.String()})
+	path := filepath.Join(dir, "terraform.tfvars.gz")
+
+	cmd := command{quiet: true}
+	entry, err := cmd.upgradeFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := filepath.Join(dir, "terragrunt.hcl"); entry.Destination != expected {
+		t.Errorf("incorrect destination: got=%s want=%s", entry.Destination, expected)
+	}
+
+	expected := `include {
+  path = find_in_parent_folders()
+}
+`
+	if diff := diff.Diff(readTree(t, dir)["terragrunt.hcl"], expected); diff != "" {
+		t.Errorf("incorrect output:\n%s", diff)
+	}
+
+	// a truncated archive is an error
+	if err := ioutil.WriteFile(path, buf.Bytes()[:buf.Len()/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd.readFile(path); err == nil || !strings.HasPrefix(err.Error(), "error decompressing") {
+		t.Errorf("expected an error decompressing a truncated archive, got: %v", err)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	cases := []struct {
+		name     string
+		cmd      command
+		expected logLevel
+		err      string
+	}{
+		{name: "default", expected: levelInfo},
+		{name: "quiet", cmd: command{quiet: true}, expected: levelError},
+		{name: "verbose", cmd: command{verbose: true}, expected: levelDebug},
+		{name: "warn", cmd: command{logLevel: "warn"}, expected: levelWarn},
+		{name: "debug", cmd: command{logLevel: "debug"}, expected: levelDebug},
+		{
+			name: "invalid",
+			cmd:  command{logLevel: "trace"},
+			err:  `invalid --log-level "trace". must be one of error, warn, info, or debug`,
+		},
+		{
+			name: "with quiet",
+			cmd:  command{logLevel: "info", quiet: true},
+			err:  "--log-level cannot be used with --quiet or --verbose",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.cmd.validateArgs([]string{"-"})
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("incorrect error: got=%v want=%s", err, c.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := c.cmd.level(); actual != c.expected {
+				t.Errorf("incorrect level: got=%d want=%d", actual, c.expected)
+			}
+		})
+	}
+
+	// messages below the level are not printed
+	cmd := command{logLevel: "warn"}
+	_, stderr := captureOutput(t, func() {
+		cmd.errorf("an error")
+		cmd.warnf("a warning")
+		cmd.infof("some info")
+		cmd.debugf("some details")
+	})
+	if expected := "error: an error\nwarning: a warning\n"; stderr != expected {
+		t.Errorf("incorrect output: got=%q want=%q", stderr, expected)
+	}
+}
+
+func TestPreviewDir(t *testing.T) {
+	config := `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`
+	chdir(t, writeTree(t, map[string]string{"live/app/terraform.tfvars": config}))
+
+	cmd := command{previewDir: "preview", quiet: true}
+	if _, _, err := captureRun(t, &cmd, filepath.Join("live", "app", "terraform.tfvars")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the original is left untouched, and there's no upgraded config next
+	// to it
+	expected := map[string]string{
+		"live/app/terraform.tfvars": config,
+		"preview/live/app/terragrunt.hcl": `include {
+  path = find_in_parent_folders()
+}
+`,
+	}
+	if actual := readTree(t, "."); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("incorrect files: got=%q want=%q", actual, expected)
+	}
+}
+
+func TestFuncUpgrades(t *testing.T) {
+	cases := []struct {
+		name    string
+		from    string
+		renames map[string]string
+		removed map[string]string
+		err     string
+	}{
+		{
+			name: "default",
+			renames: map[string]string{
+				"get_tfvars_dir":        "get_terragrunt_dir",
+				"get_parent_tfvars_dir": "get_parent_terragrunt_dir",
+			},
+			removed: map[string]string{},
+		},
+		{
+			name: "oldest",
+			from: "0.12.0",
+			renames: map[string]string{
+				"get_tfvars_dir":        "get_terragrunt_dir",
+				"get_parent_tfvars_dir": "get_parent_terragrunt_dir",
+			},
+			removed: map[string]string{},
+		},
+		{name: "too old", from: "0.11.0", err: "invalid from version 0.11.0: must be >= 0.12.0 and < 0.19.0"},
+		{name: "already upgraded", from: "v0.19", err: "invalid from version v0.19: must be >= 0.12.0 and < 0.19.0"},
+		{name: "malformed", from: "latest", err: `invalid from version: malformed version "latest"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := command{fromVersion: c.from}
+			fu, err := cmd.funcUpgrades()
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("incorrect error: got=%v want=%s", err, c.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(fu.renames, c.renames) {
+				t.Errorf("incorrect renames: got=%v want=%v", fu.renames, c.renames)
+			}
+			if !reflect.DeepEqual(fu.removed, c.removed) {
+				t.Errorf("incorrect removals: got=%v want=%v", fu.removed, c.removed)
+			}
+		})
+	}
+
+	// only changes after the from version apply, and a function renamed
+	// more than once is renamed to its newest name
+	changes := funcChanges
+	defer func() { funcChanges = changes }()
+	funcChanges = []struct {
+		version  string
+		from, to string
+	}{
+		{version: "0.14.0", from: "a", to: "b"},
+		{version: "0.15.0", from: "b", to: "c"},
+		{version: "0.15.0", from: "d", to: ""},
+		{version: "0.16.0", from: "c", to: ""},
+	}
+	cmd := command{fromVersion: "0.14.0"}
+	fu, err := cmd.funcUpgrades()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := map[string]string{}; !reflect.DeepEqual(fu.renames, expected) {
+		t.Errorf("incorrect renames: got=%v want=%v", fu.renames, expected)
+	}
+	if expected := map[string]string{"b": "0.16.0", "c": "0.16.0", "d": "0.15.0"}; !reflect.DeepEqual(fu.removed, expected) {
+		t.Errorf("incorrect removals: got=%v want=%v", fu.removed, expected)
+	}
+
+	cmd.fromVersion = "0.13.0"
+	if fu, err = cmd.funcUpgrades(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := map[string]string{"a": "0.16.0", "b": "0.16.0", "c": "0.16.0", "d": "0.15.0"}; !reflect.DeepEqual(fu.removed, expected) {
+		t.Errorf("incorrect removals: got=%v want=%v", fu.removed, expected)
+	}
+}
+
+func TestUpgradeFromVersion(t *testing.T) {
+	changes := funcChanges
+	defer func() { funcChanges = changes }()
+	funcChanges = []struct {
+		version  string
+		from, to string
+	}{
+		{version: "0.15.0", from: "get_old_dir", to: "get_tfvars_dir"},
+		{version: "0.19.0", from: "get_tfvars_dir", to: "get_terragrunt_dir"},
+	}
+
+	input := []byte(`
+terragrunt = {
+  terraform {
+    source = "${get_old_dir()}/modules"
+  }
+}
+`)
+
+	cases := []struct {
+		from     string
+		expected string
+	}{
+		{from: "0.14.0", expected: `source = "${get_terragrunt_dir()}/modules"`},
+		{from: "0.15.0", expected: `source = "${get_old_dir()}/modules"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.from, func(t *testing.T) {
+			cmd := command{fromVersion: c.from}
+			actual, err := cmd.upgrade(input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(string(actual), c.expected) {
+				t.Errorf("expected %s in output:\n%s", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestUpgradeInteractive(t *testing.T) {
+	config := `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`
+	mods := []string{"mod1", "mod2", "mod3", "mod4", "mod5"}
+	files := make(map[string]string)
+	for _, mod := range mods {
+		files[mod+"/terraform.tfvars"] = config
+	}
+	dir := writeTree(t, files)
+
+	var paths []string
+	for _, mod := range mods {
+		paths = append(paths, filepath.Join(dir, mod, "terraform.tfvars"))
+	}
+
+	stdout, _ := captureOutput(t, func() {
+		// an invalid answer is asked again. mod2 is skipped, and after "a"
+		// mod3 and mod4 are saved without asking
+		cmd := command{interactive: true, quiet: true, answers: bufio.NewReader(strings.NewReader("maybe\ny\nn\na\n"))}
+		expected := []string{statusUpgraded, statusSkipped, statusUpgraded, statusUpgraded}
+		for i, p := range paths[:4] {
+			entry, err := cmd.upgradeFile(p)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if entry.Status != expected[i] {
+				t.Errorf("incorrect status for %s: got=%s want=%s", p, entry.Status, expected[i])
+			}
+		}
+
+		// "q" stops without saving
+		cmd = command{interactive: true, quiet: true, answers: bufio.NewReader(strings.NewReader("q\n"))}
+		if entry, err := cmd.upgradeFile(paths[4]); err != errQuit || entry.Status != statusSkipped {
+			t.Errorf("expected to quit, got: %+v, %v", entry, err)
+		}
+	})
+
+	for i, p := range paths {
+		_, err := os.Stat(filepath.Join(filepath.Dir(p), "terragrunt.hcl"))
+		if upgraded := i == 0 || i == 2 || i == 3; upgraded != (err == nil) {
+			t.Errorf("%s: expected upgraded=%t, got: %v", p, upgraded, err)
+		}
+	}
+
+	if n := strings.Count(stdout, "Apply? [y/N/a/q] "); n != 5 {
+		t.Errorf("incorrect number of prompts: got=%d want=5\n%s", n, stdout)
+	}
+	if !strings.Contains(stdout, "+++ "+filepath.Join(dir, "mod1", "terragrunt.hcl")+"\n") {
+		t.Errorf("changes were not printed:\n%s", stdout)
+	}
+}
+
+func TestEditInEditor(t *testing.T) {
+	editor := os.Getenv("EDITOR")
+	defer os.Setenv("EDITOR", editor)
+
+	config := `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`
+
+	cases := []struct {
+		name     string
+		script   string
+		status   string
+		expected string
+	}{
+		{
+			name:     "saved as edited",
+			script:   `sed 's/find_in_parent_folders()/"..\/terragrunt.hcl"/' "$1" > "$1.tmp" && mv "$1.tmp" "$1"`,
+			status:   statusUpgraded,
+			expected: "include {\n  path = \"../terragrunt.hcl\"\n}\n",
+		},
+		{
+			name:   "editor fails",
+			script: "exit 1",
+			status: statusError,
+		},
+		{
+			name:   "file emptied",
+			script: `: > "$1"`,
+			status: statusError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{
+				"mod/terraform.tfvars": config,
+				"editor.sh":            "#!/bin/sh\n" + c.script + "\n",
+			})
+			script := filepath.Join(dir, "editor.sh")
+			if err := os.Chmod(script, 0755); err != nil {
+				t.Fatal(err)
+			}
+			os.Setenv("EDITOR", script)
+
+			path := filepath.Join(dir, "mod", "terraform.tfvars")
+			cmd := command{editInEditor: true, quiet: true}
+			entry, err := cmd.upgradeFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if entry.Status != c.status {
+				t.Errorf("incorrect status: got=%s want=%s (%s)", entry.Status, c.status, entry.Error)
+			}
+
+			b, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), "terragrunt.hcl"))
+			if c.expected == "" {
+				if err == nil {
+					t.Errorf("expected the config not to be saved, got:\n%s", b)
+				}
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("expected the original to be left alone: %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("expected the config to be saved: %v", err)
+			} else if string(b) != c.expected {
+				t.Errorf("incorrect result (-want, +got):\n%s\n", diff.Diff(c.expected, string(b)))
+			}
+		})
+	}
+}
+
+func TestDryRunSummary(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"terraform.tfvars": `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+
+  terraform {
+    source = "${get_tfvars_dir()}/../modules//app"
+  }
+}
+`,
+	})
+	path := filepath.Join(dir, "terraform.tfvars")
+
+	stdout, _ := captureOutput(t, func() {
+		cmd := command{dryRun: true, quiet: true}
+		if _, err := cmd.upgradeFile(path); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	expected := path + ": 12 line(s) changed (1 function(s) renamed, 1 interpolation(s) unwrapped)\n" +
+		path + " -> " + filepath.Join(dir, "terragrunt.hcl") + " (removing original):\n"
+	if !strings.HasPrefix(stdout, expected) {
+		t.Errorf("incorrect output (-want, +got):\n%s\n", diff.Diff(expected, stdout))
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the original to be left alone: %v", err)
+	}
+}
+
+func TestWarningsAsErrors(t *testing.T) {
+	files := map[string]string{
+		"mod1/terraform.tfvars": `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`,
+		// ignored, with a warning
+		"mod2/terraform.tfvars": `region = "us-east-1"
+`,
+	}
+
+	cases := []struct {
+		name             string
+		warningsAsErrors bool
+		err              string
+	}{
+		{name: "warnings"},
+		{name: "warnings as errors", warningsAsErrors: true, err: "1 warning(s) with --warnings-as-errors"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := writeTree(t, files)
+			var args []string
+			for f := range files {
+				args = append(args, filepath.Join(dir, filepath.FromSlash(f)))
+			}
+
+			cmd := command{warningsAsErrors: c.warningsAsErrors, keepOld: true, quiet: true}
+			_, _, err := captureRun(t, &cmd, args...)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("incorrect error: got=%v want=%s", err, c.err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			// every file is still processed
+			if _, err := os.Stat(filepath.Join(dir, "mod1", "terragrunt.hcl")); err != nil {
+				t.Errorf("expected mod1 to be upgraded: %v", err)
+			}
+		})
+	}
+}
+
+func TestProgress(t *testing.T) {
+	config := `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`
+	dir := writeTree(t, map[string]string{
+		"mod1/terraform.tfvars": config,
+		"mod2/terraform.tfvars": config,
+		"mod3/terraform.tfvars": config,
+	})
+	var args []string
+	for _, mod := range []string{"mod1", "mod2", "mod3"} {
+		args = append(args, filepath.Join(dir, mod, "terraform.tfvars"))
+	}
+
+	// stdout isn't a terminal, so each count is printed on its own line
+	cmd := command{progress: true, quiet: true}
+	stdout, _, err := captureRun(t, &cmd, args...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "1/3\n2/3\n3/3\n"; stdout != expected {
+		t.Errorf("incorrect output (-want, +got):\n%s\n", diff.Diff(expected, stdout))
+	}
+
+	// on a terminal, the count is cleared before other messages are
+	// printed, and redrawn after them
+	stdout, _ = captureOutput(t, func() {
+		cmd := command{progress: true, progressTTY: true}
+		cmd.printProgress(1, 2)
+		cmd.infof("Updated %s", "mod1")
+		cmd.printProgress(2, 2)
+		cmd.finishProgress()
+	})
+	if expected := "\r\033[K1/2\r\033[KUpdated mod1\n\r\033[K2/2\n"; stdout != expected {
+		t.Errorf("incorrect output (-want, +got):\n%s\n", diff.Diff(expected, stdout))
+	}
+
+	// the counts would be mixed into the upgraded config on stdout
+	cmd = command{progress: true}
+	if err := cmd.validateArgs([]string{"-"}); err == nil || err.Error() != "--progress cannot be used when reading from stdin" {
+		t.Errorf("expected an error using --progress with stdin, got: %v", err)
+	}
+}
+
+func TestExistingDestination(t *testing.T) {
+	existing := "# edited by hand\n"
+	dir := writeTree(t, map[string]string{
+		"terraform.tfvars": `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`,
+		"terragrunt.hcl": existing,
+	})
+	path := filepath.Join(dir, "terraform.tfvars")
+	dest := filepath.Join(dir, "terragrunt.hcl")
+
+	// both files are left alone without --force
+	cmd := command{quiet: true}
+	entry, err := cmd.upgradeFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entry.Status != statusSkipped || entry.Error != errDestExists.Error() {
+		t.Errorf("incorrect report entry: %+v", entry)
+	}
+	if b, err := ioutil.ReadFile(dest); err != nil || string(b) != existing {
+		t.Errorf("expected %s to be left alone, got: %q, %v", dest, b, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected %s to be left alone: %v", path, err)
+	}
+
+	// a dry run doesn't write anything, so it isn't skipped
+	cmd = command{quiet: true, dryRun: true}
+	if entry, err := cmd.upgradeFile(path); err != nil || entry.Status != statusUpgraded {
+		t.Errorf("incorrect result for dry run: %+v, %v", entry, err)
+	}
+
+	cmd = command{quiet: true, force: true}
+	if entry, err := cmd.upgradeFile(path); err != nil || entry.Status != statusUpgraded {
+		t.Fatalf("incorrect result with force: %+v, %v", entry, err)
+	}
+	if b, err := ioutil.ReadFile(dest); err != nil || string(b) != "include {\n  path = find_in_parent_folders()\n}\n" {
+		t.Errorf("expected %s to be overwritten, got: %q, %v", dest, b, err)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	flags := func(cmd *command) *flag.FlagSet {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.BoolVar(&cmd.recursive, "r", false, "")
+		fs.BoolVar(&cmd.recursive, "recursive", false, "")
+		fs.BoolVar(&cmd.keepOld, "keep", false, "")
+		cmd.excludeDirs = stringList{values: defaultExcludeDirs}
+		fs.Var(&cmd.excludeDirs, "exclude-dir", "")
+		fs.StringVar(&cmd.indent, "indent", "2", "")
+		fs.IntVar(&cmd.maxDepth, "max-depth", 0, "")
+		fs.BoolVar(&cmd.quiet, "q", false, "")
+		fs.BoolVar(&cmd.quiet, "quiet", false, "")
+		fs.BoolVar(&cmd.verbose, "verbose", false, "")
+		fs.StringVar(&cmd.logLevel, "log-level", "", "")
+		fs.Var(&cmd.renameFunctions, "rename-function", "")
+		return fs
+	}
+
+	cases := []struct {
+		name        string
+		config      string
+		args        []string
+		expected    command
+		expectedErr string
+	}{
+		{
+			name: "no config file",
+			expected: command{
+				indent:      "2",
+				excludeDirs: stringList{values: defaultExcludeDirs},
+			},
+		},
+		{
+			name: "defaults",
+			config: `
+recursive   = true
+keep        = false
+exclude-dir = [".terraform", "vendor"]
+max-depth   = 2
+`,
+			expected: command{
+				recursive:   true,
+				indent:      "2",
+				maxDepth:    2,
+				excludeDirs: stringList{values: []string{".terraform", "vendor"}},
+			},
+		},
+		{
+			name: "overridden on the command line",
+			config: `
+recursive   = true
+exclude-dir = [".terraform", "vendor"]
+indent      = 4
+`,
+			args: []string{"--exclude-dir", "modules", "--indent", "tab"},
+			expected: command{
+				recursive:   true,
+				indent:      "tab",
+				excludeDirs: stringList{values: []string{"modules"}, set: true},
+			},
+		},
+		{
+			name:   "conflicting flag on the command line",
+			config: "quiet = true\n",
+			args:   []string{"--verbose"},
+			expected: command{
+				verbose:     true,
+				indent:      "2",
+				excludeDirs: stringList{values: defaultExcludeDirs},
+			},
+		},
+		{
+			name:   "alias on the command line",
+			config: "quiet = false\nrecursive = false\n",
+			args:   []string{"-q", "-r"},
+			expected: command{
+				quiet:       true,
+				recursive:   true,
+				indent:      "2",
+				excludeDirs: stringList{values: defaultExcludeDirs},
+			},
+		},
+		{
+			name:   "rename table",
+			config: "rename-function = {\n  get_account = \"get_aws_account_id\"\n  home_dir    = \"get_terragrunt_dir\"\n}\n",
+			expected: command{
+				indent:          "2",
+				excludeDirs:     stringList{values: defaultExcludeDirs},
+				renameFunctions: stringList{values: []string{"get_account=get_aws_account_id", "home_dir=get_terragrunt_dir"}},
+			},
+		},
+		{
+			name:        "unknown flag",
+			config:      "recursive = true\nsort = true\n",
+			expectedErr: ".terragrunt-upgrade.hcl:2: unknown flag sort",
+		},
+		{
+			name:        "invalid value",
+			config:      `max-depth = "deep"`,
+			expectedErr: `.terragrunt-upgrade.hcl:1: invalid value "deep" for flag max-depth`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			files := make(map[string]string)
+			if c.config != "" {
+				files[configFile] = c.config
+			}
+			path := filepath.Join(writeTree(t, files), configFile)
+
+			var cmd command
+			fs := flags(&cmd)
+			if err := fs.Parse(c.args); err != nil {
+				t.Fatal(err)
+			}
+			err := loadDefaults(fs, path)
+			if c.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+					t.Fatalf("incorrect error: got=%v want=%s", err, c.expectedErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(cmd, c.expected) {
+				t.Errorf("incorrect flags: got=%+v want=%+v", cmd, c.expected)
+			}
+		})
+	}
+}
+
+func TestStdoutJSON(t *testing.T) {
+	upgraded := `include {
+  path = find_in_parent_folders()
+}
+`
+	files := map[string]string{
+		"mod1/terraform.tfvars": `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`,
+		// already upgraded, so it's written as it is
+		"mod2/terragrunt.hcl": upgraded,
+	}
+	dir := writeTree(t, files)
+	args := []string{
+		filepath.Join(dir, "mod1", "terraform.tfvars"),
+		filepath.Join(dir, "mod2", "terragrunt.hcl"),
+	}
+
+	cmd := command{stdoutJSON: true, quiet: true}
+	stdout, _, err := captureRun(t, &cmd, args...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(lines) != len(args) {
+		t.Fatalf("incorrect number of lines: got=%d want=%d\n%s", len(lines), len(args), stdout)
+	}
+	for i, line := range lines {
+		var obj struct {
+			Path    string `json:"path"`
+			Content string `json:"content"`
+		}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			t.Fatalf("invalid JSON on line %d: %v\n%s", i+1, err, line)
+		}
+		if obj.Path != args[i] || obj.Content != upgraded {
+			t.Errorf("incorrect object on line %d: got=%+v want path=%s content=%q", i+1, obj, args[i], upgraded)
+		}
+	}
+
+	// nothing is written or removed
+	if actual := readTree(t, dir); !reflect.DeepEqual(actual, files) {
+		t.Errorf("expected the files to be left alone: got=%q want=%q", actual, files)
+	}
+}
+
+func TestRewriteSources(t *testing.T) {
+	rewrites := stringList{values: []string{
+		"../modules=git::git@github.com:foo/modules.git",
+		"../modules//legacy=git::git@github.com:foo/legacy.git//app",
+		"${get_tfvars_dir()}/../modules=git::git@github.com:foo/modules.git",
+	}}
+
+	cases := []struct {
+		name     string
+		cmd      command
+		source   string
+		expected string
+	}{
+		{
+			name:     "no mappings",
+			source:   `"../modules//app?ref=v1.0.0"`,
+			expected: `"../modules//app?ref=v1.0.0"`,
+		},
+		{
+			name:     "prefix",
+			cmd:      command{rewriteSources: rewrites},
+			source:   `"../modules//app?ref=v1.0.0"`,
+			expected: `"git::git@github.com:foo/modules.git//app?ref=v1.0.0"`,
+		},
+		{
+			name:     "longest prefix",
+			cmd:      command{rewriteSources: rewrites},
+			source:   `"../modules//legacy"`,
+			expected: `"git::git@github.com:foo/legacy.git//app"`,
+		},
+		{
+			name:     "prefix with interpolation",
+			cmd:      command{rewriteSources: rewrites},
+			source:   `"${get_tfvars_dir()}/../modules//app"`,
+			expected: `"git::git@github.com:foo/modules.git//app"`,
+		},
+		{
+			name:     "no matching prefix",
+			cmd:      command{rewriteSources: rewrites},
+			source:   `"git::git@github.com:foo/other.git//app"`,
+			expected: `"git::git@github.com:foo/other.git//app"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// only terraform.source is rewritten, not an input variable
+			// with the same name
+			input := fmt.Sprintf("terragrunt = {\n  terraform {\n    source = %s\n  }\n}\n\nsource = \"../modules//app\"\n", c.source)
+			actual, err := c.cmd.upgrade([]byte(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expected := fmt.Sprintf("terraform {\n  source = %s\n}\n\ninputs = {\n  source = \"../modules//app\"\n}\n", c.expected)
+			if string(actual) != expected {
+				t.Errorf("incorrect result (-want, +got):\n%s\n", diff.Diff(expected, string(actual)))
+			}
+		})
+	}
+
+	cmd := command{rewriteSources: stringList{values: []string{"../modules"}}}
+	if _, err := cmd.sourceRewrites(); err == nil {
+		t.Errorf("expected an error for a mapping without a replacement")
+	}
+}
+
+func TestCollidingInputs(t *testing.T) {
+	input := `terragrunt = {
+  terraform {
+    source = "git::git@github.com:foo/bar.git"
+  }
+}
+
+terraform = "x"
+inputs = "y"
+include = "z"
+region = "us-east-1"
+`
+
+	res, err := hclv1parser.Parse([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range collidingInputs(res.Node.(*hclv1ast.ObjectList).Items[1:]) {
+		names = append(names, item.Keys[0].Token.Text)
+	}
+	if expected := []string{"terraform", "inputs", "include"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("incorrect inputs: got=%v want=%v", names, expected)
+	}
+
+	// a warning is only printed when the variables are written as
+	// top-level attributes
+	for _, noInputsBlock := range []bool{false, true} {
+		cmd := command{noInputsBlock: noInputsBlock, logLevel: "warn"}
+		var err error
+		_, stderr := captureOutput(t, func() {
+			_, err = cmd.upgrade([]byte(input))
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n := strings.Count(stderr, "has the same name as a terragrunt setting"); noInputsBlock && n != 3 || !noInputsBlock && n != 0 {
+			t.Errorf("incorrect warnings with noInputsBlock=%t:\n%s", noInputsBlock, stderr)
+		}
+	}
+}
+
+func TestConcat(t *testing.T) {
+	config := `terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+}
+`
+	files := map[string]string{
+		"mod1/terraform.tfvars": config,
+		"mod2/terraform.tfvars": config,
+	}
+	dir := writeTree(t, files)
+	paths := []string{
+		filepath.Join(dir, "mod1", "terraform.tfvars"),
+		filepath.Join(dir, "mod2", "terraform.tfvars"),
+	}
+
+	cmd := command{concatPath: filepath.Join(dir, "review.hcl"), quiet: true}
+	if _, _, err := captureRun(t, &cmd, paths...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the originals are left untouched, and there are no upgraded configs
+	// next to them
+	upgraded := `include {
+  path = find_in_parent_folders()
+}
+`
+	actual := readTree(t, dir)
+	expected := fmt.Sprintf("# === %s ===\n%s\n# === %s ===\n%s\n", paths[0], upgraded, paths[1], upgraded)
+	if diff := diff.Diff(actual["review.hcl"], expected); diff != "" {
+		t.Errorf("incorrect output:\n%s", diff)
+	}
+	delete(actual, "review.hcl")
+	if !reflect.DeepEqual(actual, files) {
+		t.Errorf("expected the originals to be left alone: got=%q want=%q", actual, files)
+	}
+}
+
+func TestHeredocInterpolations(t *testing.T) {
+	renames := map[string]string{
+		"get_tfvars_dir":        "get_terragrunt_dir",
+		"get_parent_tfvars_dir": "get_parent_terragrunt_dir",
+	}
+	cases := []struct {
+		name   string
+		input  string
+		spans  [][2]int
+		calls  []string
+		output string
+	}{
+		{
+			name:   "no interpolations",
+			input:  "get_tfvars_dir()\n",
+			output: "get_tfvars_dir()\n",
+		},
+		{
+			name:   "escaped",
+			input:  "$${get_tfvars_dir()}\n",
+			output: "$${get_tfvars_dir()}\n",
+		},
+		{
+			name:   "other functions",
+			input:  `${get_env("HOME", "")}`,
+			spans:  [][2]int{{0, 22}},
+			output: `${get_env("HOME", "")}`,
+		},
+		{
+			name:   "renamed functions",
+			input:  "${get_tfvars_dir()}/${ get_tfvars_dir( ) }/${get_parent_tfvars_dir()}\n",
+			spans:  [][2]int{{0, 19}, {20, 42}, {43, 69}},
+			calls:  []string{"get_parent_tfvars_dir", "get_tfvars_dir"},
+			output: "${get_terragrunt_dir()}/${ get_terragrunt_dir() }/${get_parent_terragrunt_dir()}\n",
+		},
+		{
+			name:   "nested braces",
+			input:  `${merge({a = get_tfvars_dir()}, {})} get_tfvars_dir()`,
+			spans:  [][2]int{{0, 36}},
+			calls:  []string{"get_tfvars_dir"},
+			output: `${merge({a = get_terragrunt_dir()}, {})} get_tfvars_dir()`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if spans := interpolations(c.input); !reflect.DeepEqual(spans, c.spans) {
+				t.Errorf("incorrect interpolations: got=%v want=%v", spans, c.spans)
+			}
+			if calls := heredocCalls(c.input, renames); !reflect.DeepEqual(calls, c.calls) {
+				t.Errorf("incorrect calls: got=%v want=%v", calls, c.calls)
+			}
+			if output, _ := renameInHeredoc(c.input, renames); output != c.output {
+				t.Errorf("incorrect output: got=%q want=%q", output, c.output)
+			}
+		})
+	}
+}
+
+func TestUpgradeStrict(t *testing.T) {
+	input := `// lead comment
+terragrunt = {
+  include {
+    path = "${find_in_parent_folders()}"
+  }
+} // line comment
+`
+
+	cmd := command{logLevel: "error"}
+	if _, err := cmd.upgrade([]byte(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cmd = command{strict: true}
+	_, err := cmd.upgrade([]byte(input))
+	if expected := `line 1: dropping comment "// lead comment" on the terragrunt attribute. the upgrade would lose part of the config`; err == nil || err.Error() != expected {
+		t.Errorf("incorrect error: got=%v want=%s", err, expected)
+	}
+
+	// without either comment, nothing is lost
+	input = input[strings.Index(input, "\n")+1 : strings.LastIndex(input, " //")]
+	if _, err := cmd.upgrade([]byte(input)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// so is a removed setting, unless it's commented out
+	input = "terragrunt = {\n  lock = {\n    backend = \"dynamodb\"\n  }\n}\n"
+	if _, err := cmd.upgrade([]byte(input)); err == nil || !strings.HasPrefix(err.Error(), "line 2: dropping lock") {
+		t.Errorf("incorrect error for removed setting: %v", err)
+	}
+	cmd.removedSettings = "comment"
+	cmd.logLevel = "error"
+	if _, err := cmd.upgrade([]byte(input)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// a node that can't be written is an error as well
+	cmd.writeErr = nil
+	cmd.writeNode("", hclv2write.NewEmptyFile().Body(), &hclv1ast.ObjectKey{Token: hclv1token.Token{Pos: hclv1token.Pos{Line: 3}}}, nil)
+	if expected := "line 3: dropping unsupported *ast.ObjectKey node. the upgrade would lose part of the config"; cmd.writeErr == nil || cmd.writeErr.Error() != expected {
+		t.Errorf("incorrect error: got=%v want=%s", cmd.writeErr, expected)
+	}
+}
+
+func TestNoFormatIndent(t *testing.T) {
+	cmd := command{noFormat: true, indent: "4"}
+	if err := cmd.validateArgs([]string{"-"}); err == nil {
+		t.Errorf("expected an error using --indent with --no-format")
+	}
+
+	cmd.indent = "2"
+	if err := cmd.validateArgs([]string{"-"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateOnly(t *testing.T) {
+	cases := []struct {
+		name string
+		only string
+		err  string
+	}{
+		{name: "valid", only: "remote_state, include"},
+		{name: "empty", only: " , ", err: "--only must list at least one terragrunt setting"},
+		{
+			name: "unknown setting",
+			only: "include,remote_sate",
+			err:  `invalid --only setting "remote_sate". must be one of dependencies, dependency, download_dir, iam_role, include, inputs, locals, prevent_destroy, remote_state, skip, terraform, terraform_binary, terraform_version_constraint`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := command{only: c.only}
+			err := cmd.validateArgs([]string{"-"})
+			if c.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if c.err != "" && (err == nil || err.Error() != c.err) {
+				t.Errorf("incorrect error: got=%v want=%s", err, c.err)
+			}
+		})
+	}
+}
+
+// writeTree writes files, keyed by slash-separated paths, under a new
+// temporary directory, and returns the directory. The directory is removed
+// when the test finishes.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "terragrunt-v19-upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for f, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// readTree returns the contents of each file under dir, keyed by
+// slash-separated paths relative to dir.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// chdir changes the current directory to dir until the test finishes.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// gitRepo creates a git repository in dir, and returns a function that runs
+// git in it and returns its output. The test is skipped if git isn't
+// available.
+func gitRepo(t *testing.T, dir string) func(args ...string) string {
+	t.Helper()
+	if err := exec.Command("git", "init", "-q", dir).Run(); err != nil {
+		t.Skipf("git is not available: %v", err)
+	}
+
+	return func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %v: %s", args[0], err, out)
+		}
+		return string(out)
+	}
+}
+
+// captureOutput runs f, and returns what it wrote to stdout and stderr.
+func captureOutput(t *testing.T, f func()) (string, string) {
+	t.Helper()
+	var files [2]*os.File
+	for i := range files {
+		out, err := ioutil.TempFile("", "terragrunt-v19-upgrade")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(out.Name())
+		defer out.Close()
+		files[i] = out
+	}
+
+	func() {
+		stdout, stderr := os.Stdout, os.Stderr
+		os.Stdout, os.Stderr = files[0], files[1]
+		defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+		f()
+	}()
+
+	var output [2]string
+	for i, out := range files {
+		b, err := ioutil.ReadFile(out.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		output[i] = string(b)
+	}
+	return output[0], output[1]
+}
+
+// captureRun runs cmd with args, and returns what it wrote to stdout and
+// stderr along with the error it returned.
+func captureRun(t *testing.T, cmd *command, args ...string) (stdout, stderr string, err error) {
+	t.Helper()
+	stdout, stderr = captureOutput(t, func() {
+		err = cmd.run(context.Background(), args)
+	})
+	return stdout, stderr, err
+}
+
+// withStdin replaces os.Stdin with a file containing input until the test
+// finishes.
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+	f, err := ioutil.TempFile("", "terragrunt-v19-upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		f.Close()
+		os.Remove(f.Name())
+	})
 }